@@ -10,6 +10,7 @@ The tree looks like this (in JSON-style syntax):
 			"Sub": {},    // Substitution map
 			"Person": {}, // Person substitution map
 			"Array": {},  // Arrays
+			"Topic": {},  // Topic options
 		},
 		"Topics": {},
 		"Objects": [],
@@ -31,6 +32,10 @@ type Begin struct {
 	Sub    map[string]string   `json:"sub"`
 	Person map[string]string   `json:"person"`
 	Array  map[string][]string `json:"array"` // Map of string (names) to arrays-of-strings
+
+	// Topic options, from `! topic <name> <option> = <value>` lines. It maps
+	// topic names to their options.
+	Topic map[string]map[string]string `json:"topic"`
 }
 
 // Topic represents a topic of conversation.
@@ -66,6 +71,7 @@ func New() *Root {
 			Sub:    map[string]string{},
 			Person: map[string]string{},
 			Array:  map[string][]string{},
+			Topic:  map[string]map[string]string{},
 		},
 		Topics:  map[string]*Topic{},
 		Objects: []*Object{},
@@ -28,6 +28,7 @@ type astTopic struct {
 }
 
 type astTrigger struct {
+	topic     string // The topic the trigger was defined in
 	trigger   string
 	reply     []string
 	condition []string
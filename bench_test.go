@@ -0,0 +1,101 @@
+package rivescript
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Messages that exercise triggers from all over the example brain, from
+// atomic triggers near the top of the sort buffer to catch-all wildcards.
+var benchMessages = []string{
+	"hello bot",
+	"my name is Aiden",
+	"what is my name",
+	"i am 21 years old",
+	"who are you",
+	"i feel sad today",
+	"can you help me with something",
+	"this message will fall through to the catch-all",
+}
+
+// newBenchBot loads the example brain for benchmarking.
+func newBenchBot(b *testing.B) *RiveScript {
+	bot := New(nil)
+	bot.Quiet = true
+	if err := bot.LoadDirectory("eg/brain"); err != nil {
+		b.Fatal(err)
+	}
+	if err := bot.SortReplies(); err != nil {
+		b.Fatal(err)
+	}
+	return bot
+}
+
+func BenchmarkSortReplies(b *testing.B) {
+	bot := newBenchBot(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bot.SortReplies()
+	}
+}
+
+func BenchmarkReply(b *testing.B) {
+	bot := newBenchBot(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bot.Reply("bench-user", benchMessages[i%len(benchMessages)])
+	}
+}
+
+func BenchmarkReplyCatchAll(b *testing.B) {
+	bot := newBenchBot(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bot.Reply("bench-user", "this message will fall through to the catch-all")
+	}
+}
+
+func BenchmarkReplyParallel(b *testing.B) {
+	bot := newBenchBot(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bot.Reply("bench-user", "hello bot")
+		}
+	})
+}
+
+// newLargeBot makes a bot with thousands of triggers, most of which start
+// with distinct words, plus a catch-all.
+func newLargeBot(b *testing.B, size int) *RiveScript {
+	var code strings.Builder
+	for i := 0; i < size; i++ {
+		fmt.Fprintf(&code, "+ word%d is a trigger\n- Atomic %d.\n", i, i)
+		fmt.Fprintf(&code, "+ word%d [has an] * wildcard\n- Wildcard %d.\n", i, i)
+	}
+	code.WriteString("+ *\n- Catch-all.\n")
+
+	bot := New(nil)
+	bot.Quiet = true
+	if err := bot.Stream(code.String()); err != nil {
+		b.Fatal(err)
+	}
+	if err := bot.SortReplies(); err != nil {
+		b.Fatal(err)
+	}
+	return bot
+}
+
+func BenchmarkReplyLargeBrain(b *testing.B) {
+	bot := newLargeBot(b, 2500)
+	messages := []string{
+		"word1234 is a trigger",
+		"word42 has an interesting wildcard",
+		"nothing here matches",
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bot.Reply("bench-user", messages[i%len(messages)])
+	}
+}
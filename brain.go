@@ -1,10 +1,16 @@
 package rivescript
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	re "regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/aichaos/rivescript-go/sessions"
 )
 
 /*
@@ -16,54 +22,309 @@ Parameters
 	message: The user's message.
 */
 func (rs *RiveScript) Reply(username, message string) (string, error) {
+	return rs.ReplyContext(context.Background(), username, message)
+}
+
+/*
+ReplyContext fetches a reply from the bot for a user's message, honoring the
+cancellation and deadline of the given context.
+
+The context is checked between the steps of trigger matching and tag
+processing, and it is handed down to object macros and session managers that
+support it. If the context is cancelled before the reply is finished, the
+error from `ctx.Err()` is returned.
+
+Parameters
+
+	ctx: The context for this request.
+	username: The name of the user requesting a reply.
+	message: The user's message.
+*/
+func (rs *RiveScript) ReplyContext(ctx context.Context, username, message string) (string, error) {
+	details, err := rs.ReplyDetailedContext(ctx, username, message)
+	if err != nil {
+		return "", err
+	}
+	return details.Reply, nil
+}
+
+/*
+ReplyDetailed fetches a reply from the bot along with the details of how it
+was found: the trigger that matched, the stars, the redirects that were
+followed, and so on. See the ReplyDetails type.
+
+Parameters
+
+	username: The name of the user requesting a reply.
+	message: The user's message.
+*/
+func (rs *RiveScript) ReplyDetailed(username, message string) (*ReplyDetails, error) {
+	return rs.ReplyDetailedContext(context.Background(), username, message)
+}
+
+/*
+ReplyDetailedContext is like ReplyDetailed, but it honors the cancellation and
+deadline of the given context like `ReplyContext()` does.
+
+If an error is returned, the ReplyDetails are still returned with whatever
+was learned before the error, such as the user's topic.
+*/
+func (rs *RiveScript) ReplyDetailedContext(ctx context.Context, username, message string) (*ReplyDetails, error) {
 	rs.say("Asked to reply to [%s] %s", username, message)
-	var err error
+
+	// Answer each sentence on its own?
+	if rs.splitSentences {
+		if sentences := rs.sentences(message); len(sentences) > 1 {
+			return rs.replySentences(ctx, username, sentences)
+		}
+	}
+
+	return rs.replyMessage(ctx, username, message)
+}
+
+/*
+replySentences answers a message that was split into several sentences.
+
+Each sentence gets its own reply, one after the other, so the user's history
+and variables are updated in between. The replies are joined together. A
+sentence that no trigger matched is left out, unless none of them matched:
+then the first fallback reply is given, if there was one (see
+Config.FallbackReplies), or else the first NoMatchError is returned.
+*/
+func (rs *RiveScript) replySentences(ctx context.Context, username string, sentences []string) (*ReplyDetails, error) {
+	started := time.Now()
+	var (
+		last     *ReplyDetails
+		replies  []string
+		answered []*ReplyDetails
+		parts    []ReplyPart
+		noMatch  error
+		fallback *ReplyDetails
+
+		buttons      []Button
+		quickReplies []Button
+		attachments  []Attachment
+	)
+
+	for _, sentence := range sentences {
+		details, err := rs.replyMessage(ctx, username, sentence)
+		if err != nil {
+			var nm *NoMatchError
+			if errors.As(err, &nm) {
+				if noMatch == nil {
+					noMatch = err
+				}
+				continue
+			}
+			return details, err
+		}
+		if details.Fallback {
+			if fallback == nil {
+				fallback = details
+			}
+			continue
+		}
+
+		last = details
+		replies = append(replies, details.Reply)
+		parts = append(parts, details.Parts...)
+		buttons = append(buttons, details.Buttons...)
+		quickReplies = append(quickReplies, details.QuickReplies...)
+		attachments = append(attachments, details.Attachments...)
+		answered = append(answered, details)
+	}
+
+	if last == nil {
+		if fallback != nil {
+			return fallback, nil
+		}
+		return &ReplyDetails{
+			Stars:    []string{},
+			Parts:    []ReplyPart{},
+			Started:  started,
+			Duration: time.Since(started),
+		}, noMatch
+	}
+
+	// The details of the whole message are those of the last sentence that
+	// got a reply, with all the replies and their payloads.
+	combined := *last
+	combined.Reply = strings.Join(replies, rs.sentenceSeparator)
+	combined.Parts = parts
+	combined.Buttons = buttons
+	combined.QuickReplies = quickReplies
+	combined.Attachments = attachments
+	combined.Sentences = answered
+	combined.Started = started
+	combined.Duration = time.Since(started)
+	return &combined, nil
+}
+
+// sentences splits a message into sentences, at the sentence delimiters that
+// are followed by whitespace or the end of the message.
+func (rs *RiveScript) sentences(message string) []string {
+	result := []string{}
+	start := 0
+	for i := 0; i < len(message); i++ {
+		for _, delim := range rs.sentenceDelimiters {
+			end := i + len(delim)
+			if delim == "" || !strings.HasPrefix(message[i:], delim) {
+				continue
+			}
+			if end < len(message) && !unicode.IsSpace(rune(message[end])) {
+				continue
+			}
+
+			if sentence := strings.TrimSpace(message[start:end]); sentence != "" {
+				result = append(result, sentence)
+			}
+			start = end
+			i = end - 1
+			break
+		}
+	}
+	if sentence := strings.TrimSpace(message[start:]); sentence != "" {
+		result = append(result, sentence)
+	}
+	return result
+}
+
+// replyMessage answers a single message for ReplyDetailedContext().
+func (rs *RiveScript) replyMessage(ctx context.Context, username, message string) (*ReplyDetails, error) {
+	// Set up the state for this request.
+	req := rs.newRequest(ctx, username, message)
+	details := req.details
+	defer func() {
+		details.Duration = time.Since(details.Started)
+	}()
+
+	// Bail early if the request is already dead.
+	if err := ctx.Err(); err != nil {
+		return details, err
+	}
 
 	// Initialize a user profile for this user?
-	rs.sessions.Init(username)
+	req.sessions.Init(username)
 
-	// Store the current user's ID.
-	rs.inReplyContext = true
-	rs.currentUser = username
+	// Move them out of a topic they've been idle in for too long.
+	rs.checkTopicTimeout(req)
 
-	// Format their message.
-	message = rs.formatMessage(message, false)
+	// Format their message, with the hooks on either side. A hook may
+	// short-circuit with a reply of its own.
 	var reply string
-
-	// If the BEGIN block exists, consult it first.
-	if _, ok := rs.topics["__begin__"]; ok {
-		var begin string
-		begin, err = rs.getReply(username, "request", true, 0)
+	text, done, err := rs.runHooks(req, HookBeforeFormat, message)
+	if err != nil {
+		return details, err
+	}
+	if done {
+		reply = text
+		message = rs.formatMessage(message, false)
+	} else {
+		message = rs.formatMessage(text, false)
+		text, done, err = rs.runHooks(req, HookAfterFormat, message)
 		if err != nil {
-			return "", err
+			return details, err
+		}
+		if done {
+			reply = text
+		} else {
+			message = text
 		}
+	}
+	req.Message = message
 
-		// OK to continue?
-		if strings.Contains(begin, "{ok}") {
-			reply, err = rs.getReply(username, message, false, 0)
+	// Find the reply.
+	if !done {
+		reply, err = rs.replyToMessage(req, message)
+		if err != nil {
+			reply, err = rs.fallbackReply(req, message, err)
 			if err != nil {
-				return "", err
+				return details, err
 			}
-			begin = strings.NewReplacer("{ok}", reply).Replace(begin)
+			details.Fallback = true
 		}
+	}
 
-		reply = begin
-		reply = rs.processTags(username, message, reply, []string{}, []string{}, 0)
-	} else {
-		reply, err = rs.getReply(username, message, false, 0)
+	reply, _, err = rs.runHooks(req, HookAfterReply, reply)
+	if err != nil {
+		return details, err
+	}
+
+	// Don't commit anything to their history if the request was cancelled.
+	if err = ctx.Err(); err != nil {
+		return details, err
+	}
+
+	// Take out the rich payloads and split it into parts, and save their
+	// message history.
+	reply = extractRich(reply, details)
+	details.Parts, reply = splitReply(reply)
+	req.sessions.AddHistory(username, req.Message, reply)
+
+	details.Reply = reply
+	return details, nil
+}
+
+// replyToMessage finds the reply to a formatted message, starting with the
+// BEGIN block if there is one.
+func (rs *RiveScript) replyToMessage(req *Request, message string) (string, error) {
+	if _, ok := rs.topics["__begin__"]; !ok {
+		return rs.getReply(req, message, false)
+	}
+
+	// If the BEGIN block exists, consult it first.
+	begin, err := rs.getReply(req, "request", true)
+	if err != nil {
+		return "", err
+	}
+
+	// OK to continue?
+	if strings.Contains(begin, "{ok}") {
+		reply, err := rs.getReply(req, message, false)
 		if err != nil {
 			return "", err
 		}
+		begin = strings.NewReplacer("{ok}", reply).Replace(begin)
 	}
 
-	// Save their message history.
-	rs.sessions.AddHistory(username, message, reply)
+	return rs.processTags(req, message, begin, []string{}, []string{})
+}
 
-	// Unset the current user's ID.
-	rs.currentUser = ""
-	rs.inReplyContext = false
+/*
+fallbackReply answers a message that no reply was found for.
 
-	return reply, nil
+The HookNoMatch hooks are given a chance to answer it first, and then the
+fallback replies for the user's topic or the global ones are used. If the
+error isn't about a missing reply, or there's no fallback, the error is
+returned.
+*/
+func (rs *RiveScript) fallbackReply(req *Request, message string, err error) (string, error) {
+	if !errors.Is(err, ErrNoTriggerMatched) && !errors.Is(err, ErrNoReplyFound) {
+		return "", err
+	}
+
+	// Let the hooks have a go.
+	reply, done, hookErr := rs.runHooks(req, HookNoMatch, message)
+	if hookErr != nil {
+		return "", hookErr
+	}
+	if done {
+		return reply, nil
+	}
+
+	// Find the fallback replies for their topic.
+	fallbacks := rs.fallbacks
+	if topic, topicErr := req.sessions.Get(req.Username, "topic"); topicErr == nil {
+		if replies, ok := rs.topicFallbacks[topic]; ok && len(replies) > 0 {
+			fallbacks = replies
+		}
+	}
+	if len(fallbacks) == 0 {
+		return "", err
+	}
+
+	reply = fallbacks[rs.randomInt(len(fallbacks))]
+	return rs.processTags(req, message, reply, []string{}, []string{})
 }
 
 /*
@@ -71,61 +332,105 @@ getReply is the internal logic behind Reply().
 
 Parameters
 
-	username: The name of the user requesting a reply.
+	req: The state of the request; its step field counts the recursion depth.
 	message: The user's message.
 	isBegin: Whether this reply is for the "BEGIN Block" context or not.
-	step: Recursion depth counter.
 */
-func (rs *RiveScript) getReply(username string, message string, isBegin bool, step uint) (string, error) {
+func (rs *RiveScript) getReply(req *Request, message string, isBegin bool) (string, error) {
 	// Needed to sort replies?
 	if len(rs.sorted.topics) == 0 {
 		rs.warn("You forgot to call SortReplies()!")
 		return "", ErrRepliesNotSorted
 	}
 
-	// Collect data on this user.
-	topic, err := rs.sessions.Get(username, "topic")
-	if err != nil {
-		topic = "random"
+	// Has the request been cancelled?
+	ctx := req.ctx
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
+	username := req.Username
+	store := req.sessions
+
+	// Collect data on this user.
+	topic := rs.currentTopic(req)
 	stars := []string{}
 	thatStars := []string{} // For %Previous
+	named := map[string]string{}
 	var reply string
+	var err error
 
 	// Avoid letting them fall into a missing topic.
 	if _, ok := rs.topics[topic]; !ok {
 		rs.warn("User %s was in an empty topic named '%s'", username, topic)
-		rs.sessions.Set(username, map[string]string{"topic": "random"})
+		rs.setTopic(req, "random")
 		topic = "random"
 	}
 
-	// Avoid deep recursion.
-	if step > rs.Depth {
-		return "", ErrDeepRecursion
-	}
-
 	// Are we in the BEGIN block?
 	if isBegin {
 		topic = "__begin__"
 	}
 
+	// Keep track of the messages being answered through redirects. If we're
+	// asked to answer the same message in the same topic again, and none of
+	// the user's variables changed in between, we're stuck in a cycle.
+	// (Unless the first time could have matched a %Previous, which is only
+	// checked before any redirects.) A loop that counts with a variable is
+	// left to the recursion limit.
+	link := chainLink{
+		message:  message,
+		topic:    topic,
+		previous: req.step == 0 && rs.topicHasPrevious(topic),
+		writes:   atomic.LoadUint64(rs.varWrites),
+	}
+	if len(req.chain) > 0 {
+		// Only the messages of a redirect chain need their variables read.
+		// The first message's are the same as now if nothing was written
+		// since.
+		link.vars, link.read = userState(req), true
+		if first := &req.chain[0]; !first.read && first.writes == link.writes {
+			first.vars, first.read = link.vars, true
+		}
+	}
+	for i, prior := range req.chain {
+		unchanged := prior.writes == link.writes || (prior.read && prior.vars == link.vars)
+		if prior.message == link.message && prior.topic == link.topic && unchanged && !prior.previous {
+			cycle := append(append([]chainLink{}, req.chain[i:]...), link)
+			return "", newRedirectCycleError(cycle)
+		}
+	}
+	req.chain = append(req.chain, link)
+	defer func() {
+		req.chain = req.chain[:len(req.chain)-1]
+	}()
+
+	// Avoid deep recursion.
+	if req.step > rs.Depth {
+		chain := []string{}
+		for _, link := range req.chain {
+			chain = append(chain, link.message)
+		}
+		return "", &DeepRecursionError{Chain: chain}
+	}
+
 	// More topic sanity checking.
 	if _, ok := rs.topics[topic]; !ok {
 		// This was handled before, which would mean topic=random and it doesn't
 		// exist. Serious issue!
-		return "", ErrNoDefaultTopic
+		return "", &MissingTopicError{Topic: topic}
 	}
 
 	// Create a pointer for the matched data when we find it.
 	var matched *astTrigger
 	matchedTrigger := ""
 	foundMatch := false
+	fuzzyDistance := -1 // Set if the message was fuzzy matched
 
 	// See if there were any %Previous's in this topic, or any topic related to
 	// it. This should only be done the first time -- not during a recursive
 	// redirection. This is because in a redirection, "lastReply" is still gonna
 	// be the same as it was the first time, resulting in an infinite loop!
-	if step == 0 {
+	if req.step == 0 {
 		allTopics := []string{topic}
 		if len(rs.includes[topic]) > 0 || len(rs.inherits[topic]) > 0 {
 			// Get ALL the topics!
@@ -133,6 +438,7 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 		}
 
 		// Scan them all.
+	previous:
 		for _, top := range allTopics {
 			rs.say("Checking topic %s for any %%Previous's.", top)
 
@@ -140,67 +446,56 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 				rs.say("There's a %%Previous in this topic!")
 
 				// Get the bot's last reply to the user.
-				history, _ := rs.sessions.GetHistory(username)
-				lastReply := history.Reply[0]
-
-				// Format the bot's reply the same way as the human's.
-				lastReply = rs.formatMessage(lastReply, true)
-				rs.say("Bot's last reply: %s", lastReply)
+				history, err := store.GetHistory(username)
+				if err != nil {
+					if ctxErr := ctx.Err(); ctxErr != nil {
+						return "", ctxErr
+					}
+					history = sessions.NewHistory()
+				}
+				// Format the bot's replies the same way as the human's, as
+				// they're needed.
+				lastReplies := map[int]string{}
 
 				// See if it's a match.
 				for _, trig := range rs.sorted.thats[top] {
+					if err := ctx.Err(); err != nil {
+						return "", err
+					}
+
 					pattern := trig.pointer.previous
-					botside := rs.triggerRegexp(username, pattern)
-					rs.say("Try to match lastReply (%s) to %s (%s)", lastReply, pattern, botside)
+					turn := previousTurn(pattern)
+					lastReply, ok := lastReplies[turn]
+					if !ok {
+						lastReply = rs.formatMessage(history.Reply[turn-1], true)
+						lastReplies[turn] = lastReply
+						rs.say("Bot's reply %d turn(s) ago: %s", turn, lastReply)
+					}
+
+					botside := rs.triggerMatcher(req, pattern)
+					rs.say("Try to match lastReply (%s) to %s (%s)", lastReply, pattern, botside.source)
 
 					// Match?
-					matcher := re.MustCompile(fmt.Sprintf("^%s$", botside))
-					match := matcher.FindStringSubmatch(lastReply)
-					if len(match) > 0 {
+					if botStars, ok := botside.match(lastReply); ok {
 						// Huzzah! See if OUR message is right too...
 						rs.say("Bot side matched!")
-
-						// Collect the bot stars.
-						thatStars = []string{}
-						if len(match) > 1 {
-							for i := range match[1:] {
-								thatStars = append(thatStars, match[i+1])
-							}
-						}
+						thatStars = botStars
 
 						// Compare the triggers to the user's message.
 						userSide := trig.pointer
-						regexp := rs.triggerRegexp(username, userSide.trigger)
-						rs.say("Try to match %s against %s (%s)", message, userSide.trigger, regexp)
-
-						// If the trigger is atomic, we don't need to deal with the regexp engine.
-						isMatch := false
-						if isAtomic(userSide.trigger) {
-							if message == regexp {
-								isMatch = true
-							}
-						} else {
-							matcher := re.MustCompile(fmt.Sprintf("^%s$", regexp))
-							match := matcher.FindStringSubmatch(message)
-							if len(match) > 0 {
-								isMatch = true
-
-								// Get the user's message stars.
-								if len(match) > 1 {
-									for i := range match[1:] {
-										stars = append(stars, match[i+1])
-									}
-								}
-							}
-						}
+						matcher := rs.triggerMatcher(req, userSide.trigger)
+						rs.say("Try to match %s against %s (%s)", message, userSide.trigger, matcher.source)
 
 						// Was it a match?
-						if isMatch {
+						if userStars, isMatch := matcher.match(message); isMatch {
+							stars = append(stars, userStars...)
+							named = matcher.named(userStars)
+
 							// Keep the trigger pointer.
 							matched = userSide
 							foundMatch = true
 							matchedTrigger = userSide.trigger
-							break
+							break previous
 						}
 					}
 				}
@@ -211,35 +506,34 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 	// Search their topic for a match to their trigger.
 	if !foundMatch {
 		rs.say("Searching their topic for a match...")
-		for _, trig := range rs.sorted.topics[topic] {
-			pattern := trig.trigger
-			regexp := rs.triggerRegexp(username, pattern)
-			rs.say("Try to match \"%s\" against %s (%s)", message, pattern, regexp)
-
-			// If the trigger is atomic, we don't need to bother with the regexp engine.
-			isMatch := false
-			if isAtomic(pattern) && message == regexp {
-				isMatch = true
-			} else {
-				// Non-atomic triggers always need the regexp.
-				matcher := re.MustCompile(fmt.Sprintf("^%s$", regexp))
-				match := matcher.FindStringSubmatch(message)
-				if len(match) > 0 {
-					// The regexp matched!
-					isMatch = true
-
-					// Collect the stars.
-					if len(match) > 1 {
-						for i := range match[1:] {
-							stars = append(stars, match[i+1])
-						}
-					}
-				}
+		triggers := rs.sorted.topics[topic]
+
+		// Only try the triggers that the index can't rule out.
+		var candidates []int
+		if idx, ok := rs.sorted.index[topic]; ok {
+			candidates = idx.candidates(message)
+		} else {
+			candidates = make([]int, len(triggers))
+			for i := range triggers {
+				candidates[i] = i
 			}
+		}
+
+		for _, i := range candidates {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+
+			trig := triggers[i]
+			pattern := trig.trigger
+			matcher := rs.triggerMatcher(req, pattern)
+			rs.say("Try to match \"%s\" against %s (%s)", message, pattern, matcher.source)
 
 			// A match somehow?
-			if isMatch {
+			if match, isMatch := matcher.match(message); isMatch {
 				rs.say("Found a match!")
+				stars = append(stars, match...)
+				named = matcher.named(match)
 
 				// Keep the pointer to this trigger's data.
 				matched = trig.pointer
@@ -248,10 +542,34 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 				break
 			}
 		}
+
+		// If nothing matched, or only a catch-all trigger like `*` did, see
+		// if the message is a typo away from one of the triggers.
+		if !isBegin && req.step == 0 {
+			if trig, distance, ok := rs.fuzzyFallback(topic, message, matchedTrigger, foundMatch); ok {
+				rs.say("Fuzzy matched \"%s\" to %s (distance %d)", message, trig.trigger, distance)
+				stars = []string{}
+				named = map[string]string{}
+				matched = trig.pointer
+				foundMatch = true
+				matchedTrigger = trig.trigger
+				fuzzyDistance = distance
+			}
+		}
 	}
 
 	// Store what trigger they matched on.
-	rs.sessions.SetLastMatch(username, matchedTrigger)
+	store.SetLastMatch(username, matchedTrigger)
+	if foundMatch {
+		req.NamedStars = named
+		if !isBegin {
+			req.recordMatch(matched, stars, thatStars, named)
+			if fuzzyDistance >= 0 {
+				req.details.Fuzzy = true
+				req.details.FuzzyDistance = fuzzyDistance
+			}
+		}
+	}
 
 	// Did we match?
 	if foundMatch {
@@ -260,10 +578,13 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 			if len(matched.redirect) > 0 {
 				rs.say("Redirecting us to %s", matched.redirect)
 				redirect := matched.redirect
-				redirect = rs.processTags(username, message, redirect, stars, thatStars, 0)
+				redirect, err = rs.processTags(req, message, redirect, stars, thatStars)
+				if err != nil {
+					return "", err
+				}
 				redirect = strings.ToLower(redirect)
 				rs.say("Pretend user said: %s", redirect)
-				reply, err = rs.getReply(username, redirect, isBegin, step+1)
+				reply, err = rs.redirect(req, redirect, isBegin, false)
 				if err != nil {
 					return "", err
 				}
@@ -274,60 +595,21 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 			for _, row := range matched.condition {
 				halves := strings.Split(row, "=>")
 				if len(halves) == 2 {
-					condition := reCondition.FindStringSubmatch(strings.TrimSpace(halves[0]))
-					if len(condition) > 0 {
-						left := strings.TrimSpace(condition[1])
-						eq := condition[2]
-						right := strings.TrimSpace(condition[3])
-						potreply := strings.TrimSpace(halves[1]) // Potential reply
-
-						// Process tags all around
-						left = rs.processTags(username, message, left, stars, thatStars, step)
-						right = rs.processTags(username, message, right, stars, thatStars, step)
-
-						// Defaults?
-						if len(left) == 0 {
-							left = UNDEFINED
-						}
-						if len(right) == 0 {
-							right = UNDEFINED
-						}
+					condition := parseCondition(strings.TrimSpace(halves[0]))
+					potreply := strings.TrimSpace(halves[1]) // Potential reply
 
-						rs.say("Check if %s %s %s", left, eq, right)
-
-						// Validate it.
-						passed := false
-						if eq == "eq" || eq == "==" {
-							if left == right {
-								passed = true
-							}
-						} else if eq == "ne" || eq == "!=" || eq == "<>" {
-							if left != right {
-								passed = true
-							}
-						} else {
-							// Dealing with numbers here.
-							iLeft, errLeft := strconv.Atoi(left)
-							iRight, errRight := strconv.Atoi(right)
-							if errLeft == nil && errRight == nil {
-								if eq == "<" && iLeft < iRight {
-									passed = true
-								} else if eq == "<=" && iLeft <= iRight {
-									passed = true
-								} else if eq == ">" && iLeft > iRight {
-									passed = true
-								} else if eq == ">=" && iLeft >= iRight {
-									passed = true
-								}
-							} else {
-								rs.warn("Failed to evaluate numeric condition!")
-							}
-						}
+					// Validate it.
+					passed, err := rs.evalCondition(req, message, condition, stars, thatStars)
+					if err != nil {
+						return "", err
+					}
 
-						if passed {
-							reply = potreply
-							break
+					if passed {
+						if !isBegin && req.inline == 0 {
+							req.details.Condition = row
 						}
+						reply = potreply
+						break
 					}
 				}
 			}
@@ -337,37 +619,16 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 				break
 			}
 
-			// Process weights in the replies.
-			bucket := []string{}
-			for _, rep := range matched.reply {
-				match := reWeight.FindStringSubmatch(rep)
-				if len(match) > 0 {
-					weight, _ := strconv.Atoi(match[1])
-					if weight <= 0 {
-						rs.warn("Can't have a weight <= 0!")
-						weight = 1
-					}
-
-					for i := weight; i > 0; i-- {
-						bucket = append(bucket, rep)
-					}
-				} else {
-					bucket = append(bucket, rep)
-				}
-			}
-
-			// Get a random reply.
-			if len(bucket) > 0 {
-				reply = bucket[rs.randomInt(len(bucket))]
-			}
+			// Pick one of the replies.
+			reply = rs.selectReply(req, matched)
 		}
 	}
 
 	// Still no reply?? Give up with the fallback error replies.
 	if !foundMatch {
-		return "", ErrNoTriggerMatched
+		return "", &NoMatchError{Topic: topic, Message: message}
 	} else if len(reply) == 0 {
-		return "", ErrNoReplyFound
+		return "", &NoMatchError{Topic: topic, Message: message, Trigger: matchedTrigger}
 	}
 
 	rs.say("Reply: %s", reply)
@@ -386,10 +647,11 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 				break
 			}
 			name := match[1]
-			rs.sessions.Set(username, map[string]string{"topic": name})
+			rs.setTopic(req, name)
 			reply = strings.Replace(reply, fmt.Sprintf("{topic=%s}", name), "", -1)
 			match = reTopic.FindStringSubmatch(reply)
 		}
+		reply = rs.processTopicStack(req, reply)
 
 		// Set user vars
 		match = reSet.FindStringSubmatch(reply)
@@ -402,13 +664,108 @@ func (rs *RiveScript) getReply(username string, message string, isBegin bool, st
 			}
 			name := match[1]
 			value := match[2]
-			rs.sessions.Set(username, map[string]string{name: value})
+			rs.setUservar(req, name, value)
 			reply = strings.Replace(reply, fmt.Sprintf("<set %s=%s>", name, value), "", -1)
 			match = reSet.FindStringSubmatch(reply)
 		}
 	} else {
-		reply = rs.processTags(username, message, reply, stars, thatStars, 0)
+		reply, err = rs.processTags(req, message, reply, stars, thatStars)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	return reply, nil
 }
+
+/*
+redirect fetches the reply for a redirection, which is either a hard `@`
+redirect of a trigger or an inline `{@...}` tag in a reply.
+
+The redirect is one step deeper than the current reply, and it is recorded in
+the details of the request.
+*/
+func (rs *RiveScript) redirect(req *Request, target string, isBegin, inline bool) (string, error) {
+	step := &Redirect{
+		Message: target,
+		Inline:  inline,
+	}
+	if !isBegin {
+		req.details.Redirects = append(req.details.Redirects, step)
+	}
+
+	parent := req.redirect
+	req.redirect = step
+	req.step++
+	if inline {
+		req.inline++
+	}
+
+	reply, err := rs.getReply(req, target, isBegin)
+
+	if inline {
+		req.inline--
+	}
+	req.step--
+	req.redirect = parent
+	return reply, err
+}
+
+// topicHasPrevious tells whether a topic, or any topic that it includes or
+// inherits, has triggers with a %Previous.
+func (rs *RiveScript) topicHasPrevious(topic string) bool {
+	topics := []string{topic}
+	if len(rs.includes[topic]) > 0 || len(rs.inherits[topic]) > 0 {
+		topics = rs.getTopicTree(topic, 0)
+	}
+	for _, top := range topics {
+		if len(rs.sorted.thats[top]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+previousTurn gets the turn of the conversation that a %Previous pattern is
+matched against, from its `{history=N}` tag: 1 is the bot's last reply (the
+default), 2 is the reply before that, and so on, up to the size of the
+history that the session managers keep.
+*/
+func previousTurn(pattern string) int {
+	match := reHistory.FindStringSubmatch(pattern)
+	if len(match) == 0 {
+		return 1
+	}
+
+	turn, _ := strconv.Atoi(match[1])
+	if turn < 1 {
+		return 1
+	} else if turn > sessions.HistorySize {
+		return sessions.HistorySize
+	}
+	return turn
+}
+
+// setUservar sets a user variable for a `<set>` tag. Setting the topic goes
+// through setTopic, like the `{topic}` tag does.
+func (rs *RiveScript) setUservar(req *Request, name, value string) {
+	if name == "topic" {
+		rs.setTopic(req, value)
+		return
+	}
+	req.sessions.Set(req.Username, map[string]string{name: value})
+}
+
+// setTopic puts the user into a new topic and records the change.
+func (rs *RiveScript) setTopic(req *Request, name string) {
+	from := rs.currentTopic(req)
+	req.sessions.Set(req.Username, map[string]string{"topic": name})
+	if from != name {
+		req.details.TopicChanges = append(req.details.TopicChanges, TopicChange{
+			From: from,
+			To:   name,
+		})
+		rs.topicChanged(req, from, name)
+	}
+}
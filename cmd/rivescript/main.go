@@ -125,6 +125,8 @@ Type a message to the bot and press Return to send it.
 		} else if strings.Contains(text, "/debug") {
 			debug, _ := bot.GetGlobal("debug")
 			color(cyan, "Debug mode is currently:", debug, "\n")
+		} else if strings.HasPrefix(text, "/explain ") {
+			explain(bot, strings.TrimPrefix(text, "/explain "))
 		} else if strings.Contains(text, "/dump t") {
 			bot.DumpTopics()
 		} else if strings.Contains(text, "/dump s") {
@@ -165,6 +167,39 @@ func color(color string, text ...string) {
 	}
 }
 
+// explain prints how the bot matched a message.
+func explain(bot *rivescript.RiveScript, message string) {
+	result, err := bot.Explain("localuser", message)
+	if err != nil {
+		color(red, "Error>", err.Error(), "\n")
+		return
+	}
+
+	color(cyan, "Message:", result.Message, "\n")
+	color(cyan, "Topic:", result.Topic, "\n")
+	for i, step := range result.Steps {
+		line := fmt.Sprintf("%d. [%s] + %s (topic %s)\n       %s",
+			i+1, step.Decision, step.Trigger, step.Topic, step.Regexp)
+		if step.Previous != "" {
+			line += fmt.Sprintf("\n       %% %s (%s, matched: %v)",
+				step.Previous, step.PreviousRegexp, step.PreviousMatched)
+		}
+
+		switch step.Decision {
+		case rivescript.ExplainSelected:
+			color(green, line, "\n")
+		case rivescript.ExplainShadowed:
+			color(yellow, line, "\n")
+		default:
+			fmt.Println(line)
+		}
+	}
+
+	if result.Trigger == "" {
+		color(red, "No trigger matched.", "\n")
+	}
+}
+
 func help() {
 	fmt.Printf(`Supported commands:
 - /help
@@ -174,6 +209,9 @@ func help() {
 - /debug [true|false]
     Enable or disable debug mode. If no setting is given, it prints
     the current debug mode.
+- /explain <message>
+    Show every trigger that was tried for the message, in priority order,
+    and which one was selected.
 - /dump <topics|sorted>
     For debugging purposes, dump the topic and sorted trigger trees.
 `)
@@ -0,0 +1,213 @@
+package rivescript
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentReplies hammers one bot from many goroutines. Run it with
+// `go test -race` to check the reply path for data races.
+func TestConcurrentReplies(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		! var name = Aiden
+
+		+ who am i
+		- You are <call>whoami</call>.
+
+		+ my name is *
+		- <set name=<star>>Nice to meet you, <get name>.
+
+		+ what is my name
+		- Your name is <get name>.
+
+		+ count
+		- <add count=1>You have counted to <get count>.
+
+		+ redirect *
+		@ <star>
+
+		+ inline
+		- {@who am i} {@what is my name}
+
+		+ stars * and *
+		- <call>stars</call>
+
+		+ who are you
+		- I am <bot name>.
+
+		+ nested
+		- <call>nested</call>
+	`)
+	bot.SortReplies()
+
+	bot.SetContextSubroutine("whoami", func(ctx context.Context, rs *RiveScript, args []string) string {
+		req, ok := RequestFromContext(ctx)
+		if !ok {
+			return "no request"
+		}
+		return req.Username
+	})
+	bot.SetContextSubroutine("stars", func(ctx context.Context, rs *RiveScript, args []string) string {
+		req, ok := RequestFromContext(ctx)
+		if !ok {
+			return "no request"
+		}
+		return fmt.Sprintf("%s:%v", req.Username, req.Stars)
+	})
+
+	bot.SetContextSubroutine("nested", func(ctx context.Context, rs *RiveScript, args []string) string {
+		// Passing the macro's context along lets the nested reply call
+		// object macros of its own.
+		req, _ := RequestFromContext(ctx)
+		reply, err := rs.ReplyContext(ctx, req.Username, "who am i")
+		if err != nil {
+			return err.Error()
+		}
+		return reply
+	})
+
+	const (
+		users   = 16
+		repeats = 25
+	)
+
+	var wg sync.WaitGroup
+	errors := make(chan error, users*repeats)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			username := fmt.Sprintf("user%d", i)
+			name := fmt.Sprintf("name%d", i)
+
+			expect := func(message, expected string) {
+				reply, err := bot.Reply(username, message)
+				if err != nil {
+					errors <- fmt.Errorf("%s: %s: %s", username, message, err)
+				} else if reply != expected {
+					errors <- fmt.Errorf("%s: %s: expected %q, got %q", username, message, expected, reply)
+				}
+			}
+
+			expect("my name is "+name, "Nice to meet you, "+name+".")
+			for j := 1; j <= repeats; j++ {
+				expect("who am i", "You are "+username+".")
+				expect("redirect who am i", "You are "+username+".")
+				expect("inline", "You are "+username+". Your name is "+name+".")
+				expect("stars a and b", username+":[a b]")
+				expect("who are you", "I am Aiden.")
+				expect("nested", "You are "+username+".")
+				expect("count", fmt.Sprintf("You have counted to %d.", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errors)
+
+	for err := range errors {
+		t.Error(err)
+	}
+
+	// Outside of a reply, there is no current user.
+	if _, err := bot.CurrentUser(); err == nil {
+		t.Error("expected an error from CurrentUser() outside of a reply")
+	}
+}
+
+// TestNestedMacroReply checks that a subroutine can fetch a reply that calls
+// another macro, even without passing its context along.
+func TestNestedMacroReply(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ outer
+		- <call>outer</call>
+
+		+ inner
+		- <call>inner</call> ok
+	`)
+	bot.SortReplies()
+
+	bot.SetSubroutine("outer", func(rs *RiveScript, args []string) string {
+		reply, err := rs.Reply("alice", "inner")
+		if err != nil {
+			return err.Error()
+		}
+		return reply
+	})
+	bot.SetSubroutine("inner", func(rs *RiveScript, args []string) string {
+		user, err := rs.CurrentUser()
+		if err != nil {
+			return err.Error()
+		}
+		return user
+	})
+
+	done := make(chan string, 1)
+	go func() {
+		reply, _ := bot.Reply("alice", "outer")
+		done <- reply
+	}()
+
+	select {
+	case reply := <-done:
+		if reply != "alice ok" {
+			t.Errorf("expected %q, got %q", "alice ok", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the nested reply deadlocked")
+	}
+}
+
+// TestSlowMacro checks that one user's slow macro doesn't hold up the macros
+// of other users.
+func TestSlowMacro(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ slow
+		- <call>slow</call>
+
+		+ fast
+		- <call>fast</call>
+	`)
+	bot.SortReplies()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	bot.SetSubroutine("slow", func(rs *RiveScript, args []string) string {
+		close(started)
+		<-release
+		return "slow"
+	})
+	bot.SetSubroutine("fast", func(rs *RiveScript, args []string) string {
+		return "fast"
+	})
+
+	go bot.Reply("alice", "slow")
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := bot.ReplyContext(ctx, "bob", "fast")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply != "fast" {
+		t.Errorf("expected %q, got %q", "fast", reply)
+	}
+
+	// With macros running for two users, CurrentUser() can't tell them apart.
+	bot.SetSubroutine("fast", func(rs *RiveScript, args []string) string {
+		if _, err := rs.CurrentUser(); err == nil {
+			return "no error"
+		}
+		return "error"
+	})
+	if reply, _ := bot.Reply("bob", "fast"); reply != "error" {
+		t.Errorf("expected CurrentUser() to fail, got %q", reply)
+	}
+}
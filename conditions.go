@@ -0,0 +1,359 @@
+package rivescript
+
+// *Condition evaluation.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+conditionNode is a parsed *Condition expression.
+
+A leaf node holds the raw text of a single comparison, such as
+`<get name> == Bob`. Other nodes join two expressions with `&&` or `||`.
+*/
+type conditionNode struct {
+	op          string // "&&", "||" or "" for a leaf
+	left, right *conditionNode
+	text        string
+}
+
+// conditionParser parses the left side of a *Condition line.
+type conditionParser struct {
+	text string
+	pos  int
+}
+
+var errConditionSyntax = errors.New("syntax error in condition")
+
+/*
+parseCondition parses the left side of a *Condition line.
+
+Comparisons can be combined with `&&` and `||` and grouped with parentheses.
+`&&` binds tighter than `||`. Conditions are split on their raw text, before
+any tags are processed, so the values from tags can't change the structure of
+the condition.
+
+If the text can't be parsed this way (for example, because a value has an
+unbalanced parenthesis in it), it's treated as a single comparison, which is
+how conditions were handled before they could be combined.
+*/
+func parseCondition(text string) *conditionNode {
+	p := &conditionParser{text: text}
+	node, err := p.parseOr()
+	p.skipSpace()
+	if err != nil || p.pos < len(p.text) {
+		return &conditionNode{text: strings.TrimSpace(text)}
+	}
+	return node
+}
+
+func (p *conditionParser) parseOr() (*conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (*conditionNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseTerm parses a group in parentheses or a single comparison.
+func (p *conditionParser) parseTerm() (*conditionNode, error) {
+	if p.consume("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, errConditionSyntax
+		}
+		return node, nil
+	}
+
+	// A comparison runs until the next top-level && or || or the closing
+	// parenthesis of its group. Parentheses inside of it, like in a regexp,
+	// must be balanced.
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.text) {
+		rest := p.text[p.pos:]
+		if depth == 0 && (strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||")) {
+			break
+		}
+		if rest[0] == '(' {
+			depth++
+		} else if rest[0] == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		p.pos++
+	}
+
+	text := strings.TrimSpace(p.text[start:p.pos])
+	if text == "" || depth != 0 {
+		return nil, errConditionSyntax
+	}
+	return &conditionNode{text: text}, nil
+}
+
+// consume skips whitespace and then the token, if it comes next.
+func (p *conditionParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.text[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) skipSpace() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+/*
+evalCondition evaluates a parsed *Condition.
+
+`&&` and `||` short-circuit, so the tags in a comparison (such as `<set>`)
+aren't processed unless the comparison needs to be checked.
+*/
+func (rs *RiveScript) evalCondition(req *Request, message string, node *conditionNode, stars, thatStars []string) (bool, error) {
+	switch node.op {
+	case "&&", "||":
+		passed, err := rs.evalCondition(req, message, node.left, stars, thatStars)
+		if err != nil {
+			return false, err
+		}
+		if passed == (node.op == "||") {
+			return passed, nil
+		}
+		return rs.evalCondition(req, message, node.right, stars, thatStars)
+	}
+	return rs.checkComparison(req, message, node.text, stars, thatStars)
+}
+
+/*
+checkComparison checks a single comparison from a *Condition.
+
+Supported comparisons:
+
+	left == right, left eq right      The values are equal.
+	left != right, left ne right, <>  The values are not equal.
+	left < right, <=, >, >=           Numeric comparisons (floats are OK).
+	left contains right               The left value contains the right one.
+	left startswith right             The left value starts with the right one.
+	left =~ regexp                    The left value matches the regexp.
+	left in @array                    The left value is an item in the array
+	                                  (ignoring case).
+	left is defined                   The left value isn't undefined.
+	left is not defined               The left value is undefined.
+
+Custom operators from `SetConditionOperator()` are used like the binary
+ones. If a comparison can't be evaluated, a ConditionError is returned. By
+default that includes numeric comparisons of values that aren't numbers; with
+`Config.LenientConditions`, the ConditionError is logged and recorded in the
+details of the reply instead, and the comparison is false. Numeric comparisons
+with an undefined value are always false.
+*/
+func (rs *RiveScript) checkComparison(req *Request, message, text string, stars, thatStars []string) (bool, error) {
+	var left, op, right string
+	var custom ConditionOperator
+	if l, o, r, fn, ok := rs.splitComparison(text); ok {
+		left, op, right, custom = l, o, strings.TrimSpace(r), fn
+	} else if match := reConditionDefined.FindStringSubmatch(text); len(match) > 0 {
+		left, op = match[1], "defined"
+		if match[2] != "" {
+			op = "not defined"
+		}
+	} else {
+		rs.warn("Couldn't parse condition: %s", text)
+		return false, nil
+	}
+
+	// Process tags all around. The right side of a regexp or an array lookup
+	// is taken as written.
+	left, err := rs.processTags(req, message, strings.TrimSpace(left), stars, thatStars)
+	if err != nil {
+		return false, err
+	}
+	if op != "=~" && op != "in" {
+		right, err = rs.processTags(req, message, right, stars, thatStars)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Defaults?
+	if len(left) == 0 {
+		left = UNDEFINED
+	}
+	if len(right) == 0 {
+		right = UNDEFINED
+	}
+
+	rs.say("Check if %s %s %s", left, op, right)
+
+	conditionError := func(err error) *ConditionError {
+		return &ConditionError{
+			Condition: text,
+			Operator:  op,
+			Left:      left,
+			Right:     right,
+			Err:       err,
+		}
+	}
+	fail := func(err error) (bool, error) {
+		return false, conditionError(err)
+	}
+
+	if custom != nil {
+		passed, err := custom(left, right)
+		if err != nil {
+			return fail(err)
+		}
+		return passed, nil
+	}
+
+	switch op {
+	case "eq", "==":
+		return left == right, nil
+	case "ne", "!=", "<>":
+		return left != right, nil
+	case "<", "<=", ">", ">=":
+		// A variable that isn't set yet can't be compared, but it isn't an
+		// error: guards like `<get n> >= 3` are checked before n is set.
+		if left == UNDEFINED || right == UNDEFINED {
+			return false, nil
+		}
+
+		fLeft, errLeft := strconv.ParseFloat(left, 64)
+		fRight, errRight := strconv.ParseFloat(right, 64)
+		if errLeft != nil || errRight != nil {
+			if !rs.lenientConditions {
+				return fail(ErrNotNumeric)
+			}
+			err := conditionError(ErrNotNumeric)
+			rs.warn("%s", err)
+			req.details.ConditionErrors = append(req.details.ConditionErrors, err)
+			return false, nil
+		}
+		switch op {
+		case "<":
+			return fLeft < fRight, nil
+		case "<=":
+			return fLeft <= fRight, nil
+		case ">":
+			return fLeft > fRight, nil
+		default:
+			return fLeft >= fRight, nil
+		}
+	case "contains":
+		return strings.Contains(left, right), nil
+	case "startswith":
+		return strings.HasPrefix(left, right), nil
+	case "=~":
+		pattern, err := regexp.Compile(right)
+		if err != nil {
+			return fail(err)
+		}
+		return pattern.MatchString(left), nil
+	case "in":
+		name := strings.TrimPrefix(right, "@")
+		array, ok := rs.array[name]
+		if !ok {
+			return fail(fmt.Errorf("array @%s doesn't exist", name))
+		}
+		for _, item := range array {
+			if strings.EqualFold(item, left) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "defined":
+		return left != UNDEFINED, nil
+	case "not defined":
+		return left == UNDEFINED, nil
+	}
+
+	return false, nil
+}
+
+// The built-in *Condition operators.
+var conditionOperators = map[string]bool{
+	"==": true, "eq": true, "!=": true, "ne": true, "<>": true,
+	"<": true, "<=": true, ">": true, ">=": true,
+	"=~": true, "contains": true, "startswith": true, "in": true,
+}
+
+/*
+splitComparison splits a comparison at its operator.
+
+The operator is the first word, after the first, that is a built-in or custom
+operator and has whitespace on both sides. For a custom operator, its
+function is returned as well.
+*/
+func (rs *RiveScript) splitComparison(text string) (left, op, right string, fn ConditionOperator, ok bool) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	pos := 0
+	for {
+		// Find the next word that follows some whitespace.
+		space := strings.IndexAny(text[pos:], " \t")
+		if space < 0 {
+			return
+		}
+		space += pos
+		start := space
+		for start < len(text) && (text[start] == ' ' || text[start] == '\t') {
+			start++
+		}
+		end := start
+		for end < len(text) && text[end] != ' ' && text[end] != '\t' {
+			end++
+		}
+		if end == len(text) {
+			return // An operator needs whitespace after it.
+		}
+		pos = end
+
+		word := text[start:end]
+		if space == 0 {
+			continue
+		}
+		if conditionOperators[word] {
+			return text[:space], word, text[end:], nil, true
+		}
+		if custom, exists := rs.conditions[word]; exists {
+			return text[:space], word, text[end:], custom, true
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package rivescript
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConditions(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		! array colors = red green blue
+
+		+ check *
+		* <star> == a && (<get age> is defined && <get age> >= 18.5 || <get vip> == true) => Compound yes.
+		* <star> contains ell                                     => Contains.
+		* <star> startswith zz                                    => Starts with.
+		* <star> =~ ^[0-9]+(px|em)$                               => Regexp.
+		* <star> in @colors                                       => Color.
+		* <get nickname> is defined                               => Has a nickname.
+		* <get pet> is not defined && <star> == b                 => No pet.
+		- Default.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		vars    map[string]string
+		message string
+		expect  string
+	}{
+		{nil, "check a", "Default."},
+		{map[string]string{"age": "18.75"}, "check a", "Compound yes."},
+		{map[string]string{"age": "18.25"}, "check a", "Default."},
+		{map[string]string{"age": "12", "vip": "true"}, "check a", "Compound yes."},
+		{nil, "check hello", "Contains."},
+		{nil, "check zzz", "Starts with."},
+		{nil, "check 12px", "Regexp."},
+		{nil, "check 12pt", "Default."},
+		{nil, "check green", "Color."},
+		{map[string]string{"nickname": "Bob"}, "check x", "Has a nickname."},
+		{nil, "check b", "No pet."},
+		{map[string]string{"pet": "cat"}, "check b", "Default."},
+	}
+	for i, test := range tests {
+		username := "user" + string(rune('a'+i))
+		for name, value := range test.vars {
+			bot.SetUservar(username, name, value)
+		}
+		reply, err := bot.Reply(username, test.message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.message, err)
+		} else if reply != test.expect {
+			t.Errorf("%q with %v: expected %q, got %q", test.message, test.vars, test.expect, reply)
+		}
+	}
+}
+
+func TestConditionOperators(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ is * near *
+		* <star1> near <star2> => Yes, they're close.
+		- No, they're far apart.
+
+		+ am i * years old
+		* <star> > <get age> => You're older than that.
+		- You're not older than that.
+	`)
+	bot.SortReplies()
+
+	distance := map[string]int{"paris": 0, "london": 340, "tokyo": 9700}
+	bot.SetConditionOperator("near", func(left, right string) (bool, error) {
+		a, okA := distance[left]
+		b, okB := distance[right]
+		if !okA || !okB {
+			return false, fmt.Errorf("unknown city")
+		}
+		return a-b < 500 && b-a < 500, nil
+	})
+
+	expectReply := func(message, expect string) {
+		t.Helper()
+		reply, err := bot.Reply("alice", message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", message, err)
+		} else if reply != expect {
+			t.Errorf("%q: expected %q, got %q", message, expect, reply)
+		}
+	}
+	expectReply("is paris near london", "Yes, they're close.")
+	expectReply("is paris near tokyo", "No, they're far apart.")
+
+	// Errors from operators come back as a ConditionError.
+	_, err := bot.Reply("alice", "is paris near atlantis")
+	var condErr *ConditionError
+	if !errors.As(err, &condErr) || condErr.Operator != "near" || condErr.Right != "atlantis" {
+		t.Errorf("expected a ConditionError for 'near', got %#v", err)
+	}
+
+	// Numeric comparisons with an undefined value are false.
+	details, err := bot.ReplyDetailed("alice", "am i 30 years old")
+	if err != nil || details.Reply != "You're not older than that." || len(details.ConditionErrors) > 0 {
+		t.Errorf("expected an undefined value to compare as false, got %q (%v, %v)", details.Reply, err, details.ConditionErrors)
+	}
+
+	// Values that aren't numbers fail the reply.
+	bot.SetUservar("alice", "age", "old")
+	_, err = bot.Reply("alice", "am i 30 years old")
+	if !errors.As(err, &condErr) || !errors.Is(err, ErrNotNumeric) || condErr.Right != "old" {
+		t.Errorf("expected a ConditionError for the non-numeric value, got %#v", err)
+	}
+
+	// With lenient conditions they're false, and the error is recorded.
+	bot.lenientConditions = true
+	details, err = bot.ReplyDetailed("alice", "am i 30 years old")
+	if err != nil || details.Reply != "You're not older than that." {
+		t.Errorf("expected a non-numeric value to compare as false, got %q (%v)", details.Reply, err)
+	}
+	if len(details.ConditionErrors) != 1 || !errors.Is(details.ConditionErrors[0], ErrNotNumeric) ||
+		details.ConditionErrors[0].Right != "old" {
+		t.Errorf("expected a ConditionError for the non-numeric value, got %v", details.ConditionErrors)
+	}
+	bot.lenientConditions = false
+	bot.SetUservar("alice", "age", "29.5")
+	expectReply("am i 30 years old", "You're older than that.")
+
+	// Once deleted, the operator is no longer recognized.
+	bot.DeleteConditionOperator("near")
+	expectReply("is paris near london", "No, they're far apart.")
+}
+
+func TestParseCondition(t *testing.T) {
+	// An unbalanced parenthesis in a value falls back to one comparison.
+	node := parseCondition("<get mood> == :) && <star> == yes")
+	if node.op != "" || node.text != "<get mood> == :) && <star> == yes" {
+		t.Errorf("expected a single comparison, got %+v", node)
+	}
+
+	// && binds tighter than ||.
+	node = parseCondition("a == 1 || b == 2 && c == 3")
+	if node.op != "||" || node.left.text != "a == 1" || node.right.op != "&&" {
+		t.Errorf("unexpected parse tree: %+v", node)
+	}
+}
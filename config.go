@@ -1,10 +1,13 @@
 package rivescript
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/aichaos/rivescript-go/macro"
 	"github.com/aichaos/rivescript-go/sessions"
@@ -49,11 +52,84 @@ type Config struct {
 	// the original casing will be preserved through wildcards and star tags.
 	CaseSensitive bool
 
+	// MathPrecision is the number of decimal places that the results of math
+	// tags like `<add>` and `<div>` are rounded to. Trailing zeros are left
+	// off, so whole numbers stay whole. It's a pointer so that zero can mean
+	// whole numbers only: nil uses the default of 2, zero rounds to whole
+	// numbers, and a negative number keeps the full precision.
+	//
+	//	precision := 0
+	//	bot := rivescript.New(&rivescript.Config{MathPrecision: &precision})
+	MathPrecision *int
+
+	// FallbackReplies are the replies to use when no trigger matches the
+	// user's message, or the trigger that matched had no reply, instead of
+	// returning ErrNoTriggerMatched or ErrNoReplyFound. If there are several,
+	// one is picked at random. Tags in the replies are processed as usual.
+	FallbackReplies []string
+
+	// TopicFallbackReplies are fallback replies for specific topics, which
+	// are used instead of FallbackReplies when the user is in that topic.
+	TopicFallbackReplies map[string][]string
+
+	// InlineRedirectErrors controls what happens when an inline `{@...}`
+	// redirect fails. The default is to put the error's text in the reply.
+	InlineRedirectErrors InlineRedirectMode
+
+	// LenientConditions makes a numeric comparison in a *Condition, like
+	// `<get age> > 18`, false when a value isn't a number, instead of failing
+	// the reply with a ConditionError. The error is logged and recorded in
+	// `ReplyDetails.ConditionErrors` instead. Default false.
+	LenientConditions bool
+
+	// ReplySelection is how a trigger with several replies picks one of
+	// them, unless it has a `{select}` tag of its own. Default SelectRandom.
+	ReplySelection ReplySelection
+
+	// SplitSentences answers each sentence of a message separately, with the
+	// user's history and variables updated in between, and joins the replies
+	// together. The details of each sentence are in `ReplyDetails.Sentences`.
+	SplitSentences bool
+
+	// SentenceDelimiters end a sentence when they're followed by whitespace
+	// or the end of the message. Default ".", "!" and "?".
+	SentenceDelimiters []string
+
+	// SentenceSeparator joins the replies to each sentence. Default " ".
+	SentenceSeparator string
+
+	// FuzzyDistance turns on fuzzy matching for messages with typos in them.
+	// When a message matches no trigger, or only a catch-all one like `*`,
+	// it's matched to the closest trigger with no wildcards, if no more than
+	// this many letters have to be added, removed or changed to turn the
+	// message into it. A trigger can set its own distance with a `{fuzzy}`
+	// tag, and `{fuzzy=0}` leaves it out. Default 0, which is off.
+	FuzzyDistance int
+
 	// SessionManager is an implementation of the same name for managing user
 	// variables for the bot. The default is the in-memory session handler.
 	SessionManager sessions.SessionManager
 }
 
+// InlineRedirectMode is what to do when an inline `{@...}` redirect fails.
+type InlineRedirectMode int
+
+// The ways to handle a failed inline redirect.
+const (
+	// InlineRedirectText puts the text of the error in the reply.
+	InlineRedirectText InlineRedirectMode = iota
+
+	// InlineRedirectEmpty replaces the redirect with an empty string.
+	InlineRedirectEmpty
+
+	// InlineRedirectFallback replaces the redirect with a fallback reply,
+	// or an empty string if there isn't one.
+	InlineRedirectFallback
+
+	// InlineRedirectError fails the whole reply with the redirect's error.
+	InlineRedirectError
+)
+
 // WithUTF8 provides a Config object that enables UTF-8 mode.
 func WithUTF8() *Config {
 	return &Config{
@@ -104,12 +180,35 @@ func (rs *RiveScript) RemoveHandler(lang string) {
 /*
 SetSubroutine defines a Go object macro from your program.
 
+Macros for different users run at the same time, and while they do,
+`rs.CurrentUser()` can't tell which user called the subroutine and returns
+an error. If the subroutine needs the user or the rest of the request, use
+`SetContextSubroutine()` and `RequestFromContext()` instead.
+
 Parameters
 
 	name: The name of your subroutine for the `<call>` tag in RiveScript.
 	fn: A function with a prototype `func(*RiveScript, []string) string`
 */
 func (rs *RiveScript) SetSubroutine(name string, fn Subroutine) {
+	rs.SetContextSubroutine(name, func(ctx context.Context, rs *RiveScript, args []string) string {
+		return fn(rs, args)
+	})
+}
+
+/*
+SetContextSubroutine defines a context-aware Go object macro from your program.
+
+The function receives the context of the reply that called it, so that it
+can respect the request's deadline or cancellation when it does slow work.
+
+Parameters
+
+	name: The name of your subroutine for the `<call>` tag in RiveScript.
+	fn: A function with a prototype
+	    `func(context.Context, *RiveScript, []string) string`
+*/
+func (rs *RiveScript) SetContextSubroutine(name string, fn ContextSubroutine) {
 	rs.cLock.Lock()
 	defer rs.cLock.Unlock()
 
@@ -130,6 +229,196 @@ func (rs *RiveScript) DeleteSubroutine(name string) {
 	delete(rs.subroutines, name)
 }
 
+/*
+SetConditionOperator defines a custom operator for *Condition lines.
+
+The operator is used between two values like the built-in ones, for example
+`* <get birthday> before 2000-01-01 => You're older than the millennium.`
+
+If the function returns an error, the reply fails with a `ConditionError`.
+The built-in operators take precedence over custom ones with the same name.
+
+Parameters
+
+	name: The name of the operator. It may not contain spaces.
+	fn: A function with a prototype `func(left, right string) (bool, error)`
+*/
+func (rs *RiveScript) SetConditionOperator(name string, fn ConditionOperator) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.conditions[name] = fn
+}
+
+/*
+DeleteConditionOperator removes a custom *Condition operator.
+
+Parameters
+
+	name: The name of the operator to be deleted.
+*/
+func (rs *RiveScript) DeleteConditionOperator(name string) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	delete(rs.conditions, name)
+}
+
+/*
+RegisterTag defines a custom tag for replies.
+
+A tag like `<weather Paris>` calls the handler for "weather" with the
+arguments "Paris", and the tag is replaced by the text the handler returns.
+Tags are processed from the inside out, so other tags can be used in the
+arguments, as in `<weather <get city>>`. The text from the handler is
+inserted as-is; any tags in it aren't processed.
+
+The built-in tags take precedence over custom ones with the same name.
+
+Parameters
+
+	name: The name of the tag.
+	fn: A function with a prototype `func(*Request, string) string`
+*/
+func (rs *RiveScript) RegisterTag(name string, fn TagHandler) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.tags[strings.ToLower(name)] = fn
+}
+
+/*
+UnregisterTag removes a custom tag.
+
+Parameters
+
+	name: The name of the tag to be removed.
+*/
+func (rs *RiveScript) UnregisterTag(name string) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	delete(rs.tags, strings.ToLower(name))
+}
+
+/*
+RegisterWildcard defines a wildcard class for triggers, backed by a regexp.
+
+The class is used in triggers like a tag, as in `+ my email is <email>`, and
+what it matches is captured into a star like the built-in wildcards are. It
+can be given a name like other wildcards, as in `<email>{address}`.
+
+Keep in mind that the class sees the user's message after it was formatted:
+in the default mode, everything but letters, numbers and spaces is removed
+from it, so an e-mail address or a decimal number can only be matched in
+UTF-8 mode with a `UnicodePunctuation` that leaves the "@" and "." alone.
+
+Triggers with wildcard classes are sorted after the ones with optionals and
+before the ones with the built-in wildcards. Wildcards should be registered
+before `SortReplies()` is called.
+
+Parameters
+
+	name: The name of the class: lowercase letters and numbers.
+	pattern: The regular expression it matches, such as `\d+(?:\.\d+)?`
+*/
+func (rs *RiveScript) RegisterWildcard(name, pattern string) error {
+	if err := checkWildcardName(name); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid regexp for wildcard %s: %s", name, err)
+	}
+
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.wildcards[name] = wildcardClass{pattern: pattern}
+	return nil
+}
+
+/*
+RegisterWildcardFunc defines a wildcard class for triggers, backed by a
+function that tells whether a value is valid.
+
+The class matches text like the `*` wildcard does, and then the function
+checks what it captured; the trigger only matches if the function returns
+true. See `RegisterWildcard()` for how classes are used.
+
+Parameters
+
+	name: The name of the class: lowercase letters and numbers.
+	fn: A function with a prototype `func(string) bool`
+*/
+func (rs *RiveScript) RegisterWildcardFunc(name string, fn func(string) bool) error {
+	if err := checkWildcardName(name); err != nil {
+		return err
+	}
+
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.wildcards[name] = wildcardClass{pattern: `.+?`, valid: fn}
+	return nil
+}
+
+/*
+UnregisterWildcard removes a wildcard class.
+
+Parameters
+
+	name: The name of the class to be removed.
+*/
+func (rs *RiveScript) UnregisterWildcard(name string) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	delete(rs.wildcards, name)
+}
+
+/*
+RegisterFormat defines a custom string format for replies.
+
+A format is used like the built-in `{uppercase}...{/uppercase}` tags: the
+text between `{name}` and `{/name}` is given to the function and replaced by
+what it returns. The `<name>` tag is a shortcut for `{name}<star>{/name}`.
+
+Custom formats are applied after the built-in ones (person, formal, sentence,
+uppercase and lowercase), in order by name. An error is returned if the name
+isn't valid, or if it's already used by a built-in tag like `<star>`, `<id>`,
+`<get>` or `{random}`, or by a built-in format.
+
+Parameters
+
+	name: The name of the format: lowercase letters and numbers.
+	fn: A function with a prototype `func(string) string`
+*/
+func (rs *RiveScript) RegisterFormat(name string, fn FormatFunc) error {
+	if err := checkFormatName(name); err != nil {
+		return err
+	}
+
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.formats[name] = fn
+	return nil
+}
+
+/*
+UnregisterFormat removes a custom string format.
+
+Parameters
+
+	name: The name of the format to be removed.
+*/
+func (rs *RiveScript) UnregisterFormat(name string) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	delete(rs.formats, name)
+}
+
 /*
 SetGlobal sets a global variable.
 
@@ -220,9 +509,12 @@ SetUservar sets a variable for a user.
 
 This is equivalent to `<set>` in RiveScript. Set the value to `undefined`
 to delete a substitution.
+
+Reserved variables, whose names start and end with two underscores (like
+`__topicstack__`), hold the bot's own state for the user and can't be set.
 */
 func (rs *RiveScript) SetUservar(username, name, value string) {
-	rs.sessions.Set(username, map[string]string{
+	rs.SetUservars(username, map[string]string{
 		name: value,
 	})
 }
@@ -234,7 +526,16 @@ Set multiple user variables by providing a map[string]string of key/value pairs.
 Equivalent to calling `SetUservar()` for each pair in the map.
 */
 func (rs *RiveScript) SetUservars(username string, data map[string]string) {
-	rs.sessions.Set(username, data)
+	vars := map[string]string{}
+	for name, value := range data {
+		if isReservedVar(name) {
+			rs.warn("Can't set the reserved user variable %s", name)
+			continue
+		}
+		vars[name] = value
+	}
+	rs.uservarsChanged()
+	rs.sessions.Set(username, vars)
 }
 
 /*
@@ -283,35 +584,69 @@ This is equivalent to `<get name>` in RiveScript. Returns `undefined` if the
 variable isn't defined.
 */
 func (rs *RiveScript) GetUservar(username, name string) (string, error) {
+	if isReservedVar(name) {
+		return UNDEFINED, fmt.Errorf("user variable %s is reserved", name)
+	}
 	return rs.sessions.Get(username, name)
 }
 
 /*
 GetUservars gets all the variables for a user.
 
-This returns a `map[string]string` containing all the user's variables.
+This returns a `map[string]string` containing all the user's variables,
+except for the reserved ones.
 */
 func (rs *RiveScript) GetUservars(username string) (*sessions.UserData, error) {
-	return rs.sessions.GetAny(username)
+	data, err := rs.sessions.GetAny(username)
+	return publicUserData(data), err
 }
 
 /*
 GetAllUservars gets all the variables for all the users.
 
 This returns a map of username (strings) to `map[string]string` of their
-variables.
+variables, except for the reserved ones.
 */
 func (rs *RiveScript) GetAllUservars() map[string]*sessions.UserData {
-	return rs.sessions.GetAll()
+	all := map[string]*sessions.UserData{}
+	for username, data := range rs.sessions.GetAll() {
+		all[username] = publicUserData(data)
+	}
+	return all
+}
+
+// isReservedVar tells whether a user variable is reserved for the bot's own
+// state, like the topic stack. Their names start and end with two
+// underscores.
+func isReservedVar(name string) bool {
+	return len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
+}
+
+// publicUserData copies a user's data without the reserved variables.
+func publicUserData(data *sessions.UserData) *sessions.UserData {
+	if data == nil {
+		return nil
+	}
+
+	public := *data
+	public.Variables = map[string]string{}
+	for name, value := range data.Variables {
+		if !isReservedVar(name) {
+			public.Variables[name] = value
+		}
+	}
+	return &public
 }
 
 // ClearUservars deletes all the variables that belong to a user.
 func (rs *RiveScript) ClearUservars(username string) {
+	rs.uservarsChanged()
 	rs.sessions.Clear(username)
 }
 
 // ClearAllUservars deletes all variables for all users.
 func (rs *RiveScript) ClearAllUservars() {
+	rs.uservarsChanged()
 	rs.sessions.ClearAll()
 }
 
@@ -334,9 +669,16 @@ The `action` can be one of the following:
 * keep: Keep the frozen copy after restoring.
 */
 func (rs *RiveScript) ThawUservars(username string, action sessions.ThawAction) error {
+	rs.uservarsChanged()
 	return rs.sessions.Thaw(username, action)
 }
 
+// uservarsChanged counts a write to user variables from outside of a reply,
+// such as from an object macro; see trackedSessions.
+func (rs *RiveScript) uservarsChanged() {
+	atomic.AddUint64(rs.varWrites, 1)
+}
+
 // LastMatch returns the user's last matched trigger.
 func (rs *RiveScript) LastMatch(username string) (string, error) {
 	return rs.sessions.GetLastMatch(username)
@@ -346,12 +688,58 @@ func (rs *RiveScript) LastMatch(username string) (string, error) {
 CurrentUser returns the current user's ID.
 
 This is only useful from within an object macro, to get the ID of the user who
-invoked the macro. This value is set at the beginning of `Reply()` and unset
-at the end, so this function will return empty outside of a reply context.
+invoked the macro; outside of an object macro, this function returns an error.
+
+When replies for different users are running object macros at the same time,
+there's no telling which one is asking, and this returns an error too. Go
+subroutines that run concurrently should be registered with
+`SetContextSubroutine()` and use `RequestFromContext()` instead. JavaScript
+macros get an `rs` object whose `CurrentUser()` always knows its request.
 */
 func (rs *RiveScript) CurrentUser() (string, error) {
-	if rs.inReplyContext {
-		return rs.currentUser, nil
+	req, err := rs.CurrentRequest()
+	if err != nil {
+		return "", err
+	}
+	return req.Username, nil
+}
+
+/*
+CurrentRequest returns the state of the request that is running an object
+macro, including the user's ID, the trigger's stars and the request metadata.
+
+Like `CurrentUser()`, this is only useful from within an object macro, and it
+returns an error if several users' replies are running macros at once. Go
+subroutines that were registered with `SetContextSubroutine()` can get the
+same thing reliably with `RequestFromContext()`.
+*/
+func (rs *RiveScript) CurrentRequest() (*Request, error) {
+	rs.stateLock.RLock()
+	defer rs.stateLock.RUnlock()
+
+	// A reply that is running a macro from inside another reply's macro
+	// stands in for its parents.
+	var current *Request
+	for i, req := range rs.macroRequests {
+		innermost := true
+		for _, other := range rs.macroRequests[i+1:] {
+			if other.nestedIn(req) {
+				innermost = false
+				break
+			}
+		}
+		if !innermost {
+			continue
+		}
+
+		if current != nil && current.Username != req.Username {
+			return nil, errors.New("object macros are running for more than one user; use RequestFromContext()")
+		}
+		current = req
+	}
+
+	if current == nil {
+		return nil, errors.New("not called from inside an object macro")
 	}
-	return "", errors.New("CurrentUser() can only be called inside a reply context")
+	return current, nil
 }
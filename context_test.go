@@ -0,0 +1,68 @@
+package rivescript
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplyContext(t *testing.T) {
+	bot := New(nil)
+	bot.Quiet = true
+	bot.Stream(`
+		+ hello bot
+		- Hello human.
+
+		+ slow
+		- <call>slow</call>
+
+		+ cancel me
+		- <call>cancel</call>done
+	`)
+	bot.SortReplies()
+
+	var cancel context.CancelFunc
+	bot.SetContextSubroutine("slow", func(ctx context.Context, rs *RiveScript, args []string) string {
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+		return "finally"
+	})
+	bot.SetContextSubroutine("cancel", func(ctx context.Context, rs *RiveScript, args []string) string {
+		cancel()
+		return ""
+	})
+
+	// A live context behaves like Reply().
+	reply, err := bot.ReplyContext(context.Background(), "alice", "hello bot")
+	if err != nil || reply != "Hello human." {
+		t.Errorf("expected 'Hello human.', got %q (err: %v)", reply, err)
+	}
+
+	// An already-cancelled context never gets to the brain.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bot.ReplyContext(ctx, "alice", "hello bot"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// Cancelling from inside an object macro stops the reply.
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := bot.ReplyContext(ctx, "alice", "cancel me"); err != context.Canceled {
+		t.Errorf("expected context.Canceled from a macro, got %v", err)
+	}
+
+	// Deadlines are passed down to context-aware subroutines.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := bot.ReplyContext(ctx, "alice", "slow"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// A cancelled reply isn't saved to the user's history.
+	history, _ := bot.sessions.GetHistory("alice")
+	if history.Input[0] != "hello bot" {
+		t.Errorf("expected only the successful reply in history, got %q", history.Input[0])
+	}
+}
@@ -0,0 +1,133 @@
+package rivescript
+
+// Structured reply results.
+
+import "time"
+
+/*
+ReplyDetails is the result of `ReplyDetailed()`: the reply, plus the details of
+how the bot came up with it.
+*/
+type ReplyDetails struct {
+	// Reply is the bot's reply to the message.
+	Reply string `json:"reply"`
+
+	// Parts are the messages of the reply, if it was split into several with
+	// the `{split}` and `{pause}` tags, or else the one message of the reply.
+	// Reply has the parts joined by spaces.
+	Parts []ReplyPart `json:"parts"`
+
+	// Trigger is the trigger that matched the user's message, and Topic is the
+	// topic the trigger belongs to. If the trigger had a %Previous, that is
+	// given in Previous. These are empty if no trigger matched.
+	Trigger  string `json:"trigger"`
+	Topic    string `json:"topic"`
+	Previous string `json:"previous,omitempty"`
+
+	// Stars are the wildcard captures from the trigger, and BotStars are the
+	// captures from its %Previous.
+	Stars    []string `json:"stars"`
+	BotStars []string `json:"botstars,omitempty"`
+
+	// NamedStars are the captures of the trigger's named wildcards, like
+	// `*{name}`, by their names. They're also in Stars by their position.
+	NamedStars map[string]string `json:"namedStars,omitempty"`
+
+	// Redirects are the `@` and `{@...}` redirects that were followed while
+	// building the reply, in the order they were followed.
+	Redirects []*Redirect `json:"redirects,omitempty"`
+
+	// Fallback is true if no reply was found for the message, and the reply
+	// came from a HookNoMatch hook or the fallback replies instead.
+	Fallback bool `json:"fallback,omitempty"`
+
+	// Fuzzy is true if the message didn't match any trigger as it was, and it
+	// was matched to the closest trigger by its edit distance instead; see
+	// Config.FuzzyDistance. FuzzyDistance is how many letters it was off by.
+	Fuzzy         bool `json:"fuzzy,omitempty"`
+	FuzzyDistance int  `json:"fuzzyDistance,omitempty"`
+
+	// Condition is the *Condition line that picked the reply, if any.
+	Condition string `json:"condition,omitempty"`
+
+	// ConditionErrors are the numeric comparisons in *Condition lines that
+	// were given values that aren't numbers, with `Config.LenientConditions`.
+	// They're taken as false, and the reply goes on.
+	ConditionErrors []*ConditionError `json:"-"`
+
+	// TopicChanges are the changes made to the user's topic during the reply.
+	TopicChanges []TopicChange `json:"topicChanges,omitempty"`
+
+	// Macros are the object macros that were called during the reply.
+	Macros []MacroCall `json:"macros,omitempty"`
+
+	// Buttons, QuickReplies and Attachments are the rich payloads from the
+	// `{button}`, `{quickreply}` and `{image}` tags of the reply, which are
+	// removed from its text.
+	Buttons      []Button     `json:"buttons,omitempty"`
+	QuickReplies []Button     `json:"quickReplies,omitempty"`
+	Attachments  []Attachment `json:"attachments,omitempty"`
+
+	// Sentences are the details for each sentence of the message, when
+	// Config.SplitSentences is on and the message had more than one. The
+	// other fields then describe the last sentence that got a reply, except
+	// for Reply, which has all the replies joined together.
+	Sentences []*ReplyDetails `json:"sentences,omitempty"`
+
+	// Started is when the reply was requested, and Duration is how long it
+	// took to answer.
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Redirect describes a redirection that was followed during a reply.
+type Redirect struct {
+	// Message is the text that was redirected to, as though the user had
+	// sent it.
+	Message string `json:"message"`
+
+	// Inline is true for `{@...}` tags and false for `@` redirects.
+	Inline bool `json:"inline"`
+
+	// Trigger is the trigger that the redirected message matched, and Topic
+	// is the topic that trigger belongs to.
+	Trigger string `json:"trigger"`
+	Topic   string `json:"topic"`
+}
+
+// TopicChange describes a user moving from one topic to another.
+type TopicChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MacroCall describes a call to an object macro.
+type MacroCall struct {
+	Name     string        `json:"name"`
+	Language string        `json:"language"` // "go" for Go subroutines
+	Args     []string      `json:"args"`
+	Duration time.Duration `json:"duration"`
+
+	// Err is a *MacroError if the macro failed.
+	Err error `json:"-"`
+}
+
+// recordMatch notes a matched trigger in the details of the request.
+//
+// The first trigger matched is the one that the user's message matched; the
+// triggers matched afterwards were reached by redirects.
+func (req *Request) recordMatch(trig *astTrigger, stars, thatStars []string, named map[string]string) {
+	if req.redirect != nil {
+		req.redirect.Trigger = trig.trigger
+		req.redirect.Topic = trig.topic
+		return
+	}
+
+	details := req.details
+	details.Trigger = trig.trigger
+	details.Topic = trig.topic
+	details.Previous = trig.previous
+	details.Stars = stars
+	details.BotStars = thatStars
+	details.NamedStars = named
+}
@@ -0,0 +1,86 @@
+package rivescript
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplyDetailed(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ my name is *
+		* <star> == bob => Hey, it's Bob!
+		- Nice to meet you, <star>.
+
+		+ hello
+		- Hi!
+
+		+ hey
+		@ hello
+
+		+ greet me
+		- {@hello} <call>shout hello</call>
+
+		+ play a game
+		- {topic=game}Okay, let's play.
+
+		> topic game
+			+ *
+			- We're playing a game.
+		< topic
+	`)
+	bot.SortReplies()
+	bot.SetSubroutine("shout", func(rs *RiveScript, args []string) string {
+		return "HELLO"
+	})
+
+	// A simple match with stars and a condition.
+	details, err := bot.ReplyDetailed("alice", "my name is bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if details.Reply != "Hey, it's Bob!" || details.Trigger != "my name is *" || details.Topic != "random" {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	if !reflect.DeepEqual(details.Stars, []string{"bob"}) {
+		t.Errorf("expected stars [bob], got %v", details.Stars)
+	}
+	if details.Condition != "<star> == bob => Hey, it's Bob!" {
+		t.Errorf("unexpected condition: %q", details.Condition)
+	}
+
+	// Redirects.
+	details, _ = bot.ReplyDetailed("alice", "hey")
+	if details.Reply != "Hi!" || details.Trigger != "hey" || len(details.Redirects) != 1 {
+		t.Fatalf("unexpected details for a redirect: %+v", details)
+	}
+	if r := details.Redirects[0]; r.Message != "hello" || r.Trigger != "hello" || r.Inline {
+		t.Errorf("unexpected redirect: %+v", r)
+	}
+
+	// Inline redirects and macros.
+	details, _ = bot.ReplyDetailed("alice", "greet me")
+	if details.Reply != "Hi! HELLO" || len(details.Redirects) != 1 || !details.Redirects[0].Inline {
+		t.Errorf("unexpected details for an inline redirect: %+v", details)
+	}
+	if len(details.Macros) != 1 || details.Macros[0].Name != "shout" || details.Macros[0].Language != "go" {
+		t.Errorf("unexpected macro calls: %+v", details.Macros)
+	}
+
+	// Topic changes.
+	details, _ = bot.ReplyDetailed("alice", "play a game")
+	if !reflect.DeepEqual(details.TopicChanges, []TopicChange{{From: "random", To: "game"}}) {
+		t.Errorf("unexpected topic changes: %+v", details.TopicChanges)
+	}
+	details, _ = bot.ReplyDetailed("alice", "anything")
+	if details.Topic != "game" || details.Trigger != "*" {
+		t.Errorf("expected to match * in topic game, got %+v", details)
+	}
+
+	// Nothing matched.
+	details, err = bot.ReplyDetailed("bob", "hey there")
+	if !errors.Is(err, ErrNoTriggerMatched) || details.Trigger != "" {
+		t.Errorf("expected no match, got %+v (err: %v)", details, err)
+	}
+}
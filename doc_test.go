@@ -1,6 +1,7 @@
 package rivescript_test
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/aichaos/rivescript-go"
@@ -105,9 +106,9 @@ func ExampleRiveScript_subroutine() {
 	bot := rivescript.New(nil)
 
 	// Define an object macro named `setname`
-	bot.SetSubroutine("setname", func(rs *rivescript.RiveScript, args []string) string {
-		uid, _ := rs.CurrentUser()
-		rs.SetUservar(uid, args[0], args[1])
+	bot.SetContextSubroutine("setname", func(ctx context.Context, rs *rivescript.RiveScript, args []string) string {
+		req, _ := rivescript.RequestFromContext(ctx)
+		rs.SetUservar(req.Username, args[0], args[1])
 		return ""
 	})
 
@@ -125,3 +126,37 @@ func ExampleRiveScript_subroutine() {
 	reply, _ := bot.Reply("local-user", "What is my name?")
 	fmt.Printf("Bot: %s\n", reply)
 }
+
+func ExampleRiveScript_topicTimeout() {
+	// Example for moving idle users out of a topic. The `! topic` lines are
+	// an extension of this package; other RiveScript interpreters won't
+	// understand them, so keep them out of brains that you share.
+	bot := rivescript.New(nil)
+
+	bot.Stream(`
+		! topic game timeout = 30m
+		! topic game timeout_topic = random
+
+		+ let's play
+		- Let's play a game!{topic=game}
+
+		> topic game
+			+ *
+			- You're playing the game. Say "quit" to stop.
+
+			+ quit
+			- Thanks for playing!{topic=random}
+		< topic
+	`)
+	bot.SortReplies()
+
+	// Timeouts and lifecycle hooks can be set from Go, too.
+	cfg := bot.GetTopicConfig("game")
+	cfg.OnLeave = func(req *rivescript.Request, from, to string) {
+		fmt.Printf("%s stopped playing\n", req.Username)
+	}
+	bot.SetTopicConfig("game", cfg)
+
+	reply, _ := bot.Reply("local-user", "Let's play")
+	fmt.Printf("Bot: %s\n", reply)
+}
@@ -60,6 +60,11 @@ type Response struct {
 	Error  string            `json:"error,omitempty"`
 	Reply  string            `json:"reply,omitempty"`
 	Vars   map[string]string `json:"vars,omitempty"`
+
+	// Rich payloads from the reply's {button}, {quickreply} and {image} tags.
+	Buttons      []rivescript.Button     `json:"buttons,omitempty"`
+	QuickReplies []rivescript.Button     `json:"quickReplies,omitempty"`
+	Attachments  []rivescript.Attachment `json:"attachments,omitempty"`
 }
 
 // ReplyHandler is the JSON endpoint for the RiveScript bot.
@@ -97,7 +102,7 @@ func ReplyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get a reply from the bot.
-	reply, err := Bot.Reply(params.Username, params.Message)
+	details, err := Bot.ReplyDetailed(params.Username, params.Message)
 	if err != nil {
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -120,8 +125,12 @@ func ReplyHandler(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Status: "ok",
 		Error:  "",
-		Reply:  reply,
+		Reply:  details.Reply,
 		Vars:   vars,
+
+		Buttons:      details.Buttons,
+		QuickReplies: details.QuickReplies,
+		Attachments:  details.Attachments,
 	}
 
 	out, _ := json.MarshalIndent(response, "", "  ")
@@ -29,6 +29,12 @@ func init() {
 
 		+ how old am i
 		- You are <get age> years old.
+
+		+ do you want pizza
+		- Do you want pizza?{quickreply=Yes please|yes pizza}{quickreply=No|no pizza}
+
+		+ yes pizza
+		- {image=https://example.com/pizza.png}Here it is!
 	`)
 	Bot.SortReplies()
 }
@@ -131,6 +137,34 @@ func TestBobVars(t *testing.T) {
 	testReply("I am 10 years old", "I will remember you are 10 years old.")
 }
 
+func TestRichReplies(t *testing.T) {
+	res := post(t, Request{
+		Username: "carol",
+		Message:  "Do you want pizza?",
+	})
+	assert(t, res, "Do you want pizza?")
+	expect := []rivescript.Button{
+		{Label: "Yes please", Payload: "yes pizza"},
+		{Label: "No", Payload: "no pizza"},
+	}
+	if !reflect.DeepEqual(res.QuickReplies, expect) {
+		t.Errorf("expected quick replies %v, got %v", expect, res.QuickReplies)
+	}
+
+	// The client sends the payload of the quick reply the user picked.
+	res = post(t, Request{
+		Username: "carol",
+		Message:  res.QuickReplies[0].Payload,
+	})
+	assert(t, res, "Here it is!")
+	attachments := []rivescript.Attachment{
+		{Type: "image", URL: "https://example.com/pizza.png"},
+	}
+	if !reflect.DeepEqual(res.Attachments, attachments) {
+		t.Errorf("expected attachments %v, got %v", attachments, res.Attachments)
+	}
+}
+
 // post handles the common logic for POSTing to the /reply endpoint.
 func post(t *testing.T, params Request) Response {
 	payload, err := json.Marshal(params)
@@ -1,6 +1,10 @@
 package rivescript
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // The types of errors returned by RiveScript.
 var (
@@ -9,4 +13,160 @@ var (
 	ErrNoDefaultTopic   = errors.New("no default topic 'random' was found")
 	ErrNoTriggerMatched = errors.New("no trigger matched")
 	ErrNoReplyFound     = errors.New("the trigger matched but yielded no reply")
+	ErrNotNumeric       = errors.New("values are not numeric")
+	ErrObjectNotFound   = errors.New("object not found")
+	ErrRedirectCycle    = errors.New("redirect cycle detected")
 )
+
+/*
+ConditionError is returned when a *Condition line couldn't be evaluated, for
+example when a custom condition operator returns an error, or a numeric
+comparison is given values that aren't numbers. With
+`Config.LenientConditions`, the numeric comparison doesn't fail the reply; its
+ConditionError is recorded in `ReplyDetails.ConditionErrors` instead.
+
+The underlying error is available with `errors.Unwrap()`, so you may check
+for it with `errors.Is()`.
+*/
+type ConditionError struct {
+	Condition string // The condition that failed, as written
+	Operator  string // The operator that was used
+	Left      string // The left value, after its tags were processed
+	Right     string // The right value
+	Err       error  // The underlying error
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("condition '%s' failed: %s", e.Condition, e.Err)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+/*
+NoMatchError is returned when no reply was found for a message.
+
+It matches ErrNoTriggerMatched with `errors.Is()` when no trigger matched the
+message, or ErrNoReplyFound when a trigger matched but it had no reply.
+*/
+type NoMatchError struct {
+	Topic   string // The topic that was searched
+	Message string // The message, after it was formatted for matching
+	Trigger string // The trigger that matched without a reply, if any
+}
+
+func (e *NoMatchError) Error() string {
+	return e.sentinel().Error()
+}
+
+// Is makes the error match its sentinel error.
+func (e *NoMatchError) Is(target error) bool {
+	return target == e.sentinel()
+}
+
+func (e *NoMatchError) sentinel() error {
+	if e.Trigger != "" {
+		return ErrNoReplyFound
+	}
+	return ErrNoTriggerMatched
+}
+
+/*
+DeepRecursionError is returned when a reply redirects too many times, for
+example because of a loop of redirects. It matches ErrDeepRecursion with
+`errors.Is()`.
+*/
+type DeepRecursionError struct {
+	// Chain is the messages that were being answered when the limit was
+	// reached: the user's message first, followed by each redirect.
+	Chain []string
+}
+
+func (e *DeepRecursionError) Error() string {
+	return ErrDeepRecursion.Error()
+}
+
+// Is makes the error match ErrDeepRecursion.
+func (e *DeepRecursionError) Is(target error) bool {
+	return target == ErrDeepRecursion
+}
+
+/*
+RedirectCycleError is returned when a reply redirects back to a message that
+it's already answering, in the same topic, which would loop forever.
+
+It matches ErrRedirectCycle with `errors.Is()`, and ErrDeepRecursion too,
+which is how these loops were reported before they were detected.
+*/
+type RedirectCycleError struct {
+	// Cycle is the redirects that make up the loop. The first and last steps
+	// are the same message.
+	Cycle []CycleStep
+}
+
+// CycleStep is a message in a redirect cycle, and the topic it was in.
+type CycleStep struct {
+	Message string
+	Topic   string
+}
+
+func newRedirectCycleError(chain []chainLink) *RedirectCycleError {
+	err := &RedirectCycleError{}
+	for _, link := range chain {
+		err.Cycle = append(err.Cycle, CycleStep{Message: link.message, Topic: link.topic})
+	}
+	return err
+}
+
+func (e *RedirectCycleError) Error() string {
+	messages := []string{}
+	for _, step := range e.Cycle {
+		messages = append(messages, step.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrRedirectCycle, strings.Join(messages, " -> "))
+}
+
+// Is makes the error match ErrRedirectCycle and ErrDeepRecursion.
+func (e *RedirectCycleError) Is(target error) bool {
+	return target == ErrRedirectCycle || target == ErrDeepRecursion
+}
+
+/*
+MissingTopicError is returned when a topic that's needed to answer a message
+doesn't exist, such as the default topic "random". It matches
+ErrNoDefaultTopic with `errors.Is()`.
+*/
+type MissingTopicError struct {
+	Topic string
+}
+
+func (e *MissingTopicError) Error() string {
+	return ErrNoDefaultTopic.Error()
+}
+
+// Is makes the error match ErrNoDefaultTopic.
+func (e *MissingTopicError) Is(target error) bool {
+	return target == ErrNoDefaultTopic
+}
+
+/*
+MacroError describes an object macro that failed, such as a JavaScript
+function that threw an exception or an object that doesn't exist.
+
+A failed macro doesn't fail the reply; the error is logged as a warning and
+recorded in the MacroCall for the macro in the ReplyDetails.
+*/
+type MacroError struct {
+	Object   string // The name of the object macro
+	Language string // The programming language of the macro
+	Err      error  // The underlying error
+}
+
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("object macro %s (%s): %s", e.Object, e.Language, e.Err)
+}
+
+func (e *MacroError) Unwrap() error {
+	return e.Err
+}
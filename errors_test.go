@@ -0,0 +1,198 @@
+package rivescript
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aichaos/rivescript-go/sessions"
+	"github.com/aichaos/rivescript-go/sessions/memory"
+)
+
+func TestTypedErrors(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ ping
+		@ pong
+
+		+ pong
+		@ ping
+
+		+ deeper *
+		@ deeper <star> x
+
+		+ silent
+		* <get x> == y => Only sometimes.
+	`)
+	bot.SortReplies()
+	bot.Depth = 4
+
+	// No match.
+	_, err := bot.Reply("alice", "Hello, World!")
+	var noMatch *NoMatchError
+	if !errors.As(err, &noMatch) || !errors.Is(err, ErrNoTriggerMatched) {
+		t.Fatalf("expected a NoMatchError, got %#v", err)
+	}
+	if noMatch.Topic != "random" || noMatch.Message != "hello world" || noMatch.Trigger != "" {
+		t.Errorf("unexpected NoMatchError: %+v", noMatch)
+	}
+
+	// No reply.
+	_, err = bot.Reply("alice", "silent")
+	if !errors.As(err, &noMatch) || !errors.Is(err, ErrNoReplyFound) || noMatch.Trigger != "silent" {
+		t.Errorf("expected a NoMatchError for ErrNoReplyFound, got %#v", err)
+	}
+
+	// Deep recursion.
+	_, err = bot.Reply("alice", "deeper a")
+	var deep *DeepRecursionError
+	if !errors.As(err, &deep) || !errors.Is(err, ErrDeepRecursion) {
+		t.Fatalf("expected a DeepRecursionError, got %#v", err)
+	}
+	expect := []string{"deeper a", "deeper a x", "deeper a x x", "deeper a x x x",
+		"deeper a x x x x", "deeper a x x x x x"}
+	if !reflect.DeepEqual(deep.Chain, expect) {
+		t.Errorf("expected chain %v, got %v", expect, deep.Chain)
+	}
+
+	// A redirect cycle is stopped as soon as it comes back around.
+	_, err = bot.Reply("alice", "ping")
+	var cycle *RedirectCycleError
+	if !errors.As(err, &cycle) || !errors.Is(err, ErrRedirectCycle) || !errors.Is(err, ErrDeepRecursion) {
+		t.Fatalf("expected a RedirectCycleError, got %#v", err)
+	}
+	expectCycle := []CycleStep{{"ping", "random"}, {"pong", "random"}, {"ping", "random"}}
+	if !reflect.DeepEqual(cycle.Cycle, expectCycle) {
+		t.Errorf("expected cycle %v, got %v", expectCycle, cycle.Cycle)
+	}
+	if err.Error() != "redirect cycle detected: ping -> pong -> ping" {
+		t.Errorf("unexpected error text: %s", err)
+	}
+
+	// Missing topic.
+	delete(bot.topics, "random")
+	_, err = bot.Reply("alice", "hello")
+	var missing *MissingTopicError
+	if !errors.As(err, &missing) || !errors.Is(err, ErrNoDefaultTopic) || missing.Topic != "random" {
+		t.Errorf("expected a MissingTopicError, got %#v", err)
+	}
+}
+
+func TestMacroError(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ call missing
+		- <call>nothing here</call>
+	`)
+	bot.SortReplies()
+
+	details, err := bot.ReplyDetailed("alice", "call missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(details.Macros) != 1 {
+		t.Fatalf("expected one macro call, got %+v", details.Macros)
+	}
+
+	var macroErr *MacroError
+	if !errors.As(details.Macros[0].Err, &macroErr) || macroErr.Object != "nothing" ||
+		!errors.Is(macroErr, ErrObjectNotFound) {
+		t.Errorf("expected a MacroError for the missing object, got %#v", details.Macros[0].Err)
+	}
+}
+
+// A redirect to the same message isn't a cycle if the first time around
+// matched a %Previous, which is only checked before any redirects.
+func TestRedirectToSelfFromPrevious(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ hello
+		- Do you like cake?
+
+		+ yes
+		% do you like cake
+		- {@yes} Me too!
+
+		+ yes
+		- Yes what?
+	`)
+	bot.SortReplies()
+
+	bot.Reply("alice", "hello")
+	reply, err := bot.Reply("alice", "yes")
+	if err != nil || reply != "Yes what? Me too!" {
+		t.Errorf("expected the redirect to reach the other trigger, got %q (%v)", reply, err)
+	}
+}
+
+// A redirect to the same message isn't a cycle if a variable changed in
+// between, so a loop that counts with a variable can still end.
+func TestRedirectToSelfWithState(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ start
+		- <set n=0>{@count}
+
+		+ count
+		* <get n> >= 3 => done <get n>
+		- <add n=1>{@count}
+	`)
+	bot.SortReplies()
+
+	reply, err := bot.Reply("alice", "start")
+	if err != nil || reply != "done 3" {
+		t.Errorf("expected the loop to finish, got %q (%v)", reply, err)
+	}
+}
+
+// countingSessions counts the reads of all of a user's variables.
+type countingSessions struct {
+	sessions.SessionManager
+	reads int
+}
+
+func (s *countingSessions) GetAny(username string) (*sessions.UserData, error) {
+	s.reads++
+	return s.SessionManager.GetAny(username)
+}
+
+// Cycle detection only reads the user's variables once a redirect happens,
+// and it notices the changes that object macros make.
+func TestRedirectCycleReads(t *testing.T) {
+	store := &countingSessions{SessionManager: memory.New()}
+	bot := New(&Config{SessionManager: store})
+	bot.Stream(`
+		+ hello
+		- Hi there!
+
+		+ greet
+		@ hello
+
+		+ count
+		* <get n> >= 3 => done <get n>
+		* <call>increment</call> == never => Never.
+		- {@count}
+	`)
+	bot.SortReplies()
+	bot.SetSubroutine("increment", func(rs *RiveScript, args []string) string {
+		n, _ := rs.GetUservar("alice", "n")
+		i, _ := strconv.Atoi(n)
+		rs.SetUservar("alice", "n", strconv.Itoa(i+1))
+		return ""
+	})
+
+	bot.Reply("alice", "hello")
+	if store.reads != 0 {
+		t.Errorf("expected no reads of the user's variables without redirects, got %d", store.reads)
+	}
+	bot.Reply("alice", "greet")
+	if store.reads == 0 {
+		t.Errorf("expected the user's variables to be read for a redirect")
+	}
+
+	reply, err := bot.Reply("alice", "count")
+	if err != nil || reply != "done 3" {
+		t.Errorf("expected the loop to finish, got %q (%v)", reply, err)
+	}
+}
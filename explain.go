@@ -0,0 +1,229 @@
+package rivescript
+
+// Explaining how a message is matched.
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aichaos/rivescript-go/sessions"
+)
+
+/*
+Explanation is the result of `Explain()`: every trigger that was considered
+for a message, in the order that the bot tries them.
+*/
+type Explanation struct {
+	// Message is the user's message, after it was formatted for matching.
+	Message string `json:"message"`
+
+	// Topic is the topic the user is in.
+	Topic string `json:"topic"`
+
+	// LastReply is the bot's last reply to the user, formatted the same way,
+	// which is what %Previous patterns are matched against (unless they look
+	// further back with a `{history}` tag).
+	LastReply string `json:"lastReply"`
+
+	// Steps are the triggers that were considered, in priority order.
+	// Triggers with a %Previous come first, as they do for `Reply()`.
+	Steps []ExplainStep `json:"steps"`
+
+	// Trigger is the trigger that was selected and Stars are its wildcard
+	// captures. Trigger is empty if nothing matched.
+	Trigger string   `json:"trigger"`
+	Stars   []string `json:"stars"`
+
+	// Fuzzy is true if the trigger was selected by fuzzy matching, and
+	// FuzzyDistance is how many letters the message was off by.
+	Fuzzy         bool `json:"fuzzy,omitempty"`
+	FuzzyDistance int  `json:"fuzzyDistance,omitempty"`
+}
+
+// ExplainStep describes how one trigger was tested against the message.
+type ExplainStep struct {
+	// Topic is the topic that the trigger belongs to, which may be a topic
+	// that the user's topic includes or inherits.
+	Topic string `json:"topic"`
+
+	// Trigger is the trigger pattern and Regexp is the regular expression it
+	// was compiled into for this user.
+	Trigger string `json:"trigger"`
+	Regexp  string `json:"regexp"`
+
+	// Previous is the %Previous pattern of the trigger, if it has one, and
+	// PreviousRegexp is its regular expression. PreviousMatched tells whether
+	// it matched the bot's last reply.
+	Previous        string `json:"previous,omitempty"`
+	PreviousRegexp  string `json:"previousRegexp,omitempty"`
+	PreviousMatched bool   `json:"previousMatched"`
+
+	// Matched tells whether the trigger matched the user's message.
+	Matched bool `json:"matched"`
+
+	// Decision is the outcome for this trigger.
+	Decision ExplainDecision `json:"decision"`
+}
+
+// ExplainDecision is the outcome for a trigger in an Explanation.
+type ExplainDecision string
+
+// Outcomes for a trigger in an Explanation.
+const (
+	// The trigger was selected to reply to the message.
+	ExplainSelected ExplainDecision = "selected"
+
+	// The trigger matched, but a trigger with a higher priority was selected.
+	ExplainShadowed ExplainDecision = "shadowed"
+
+	// The trigger's %Previous didn't match the bot's last reply.
+	ExplainPreviousFailed ExplainDecision = "previous failed"
+
+	// The trigger didn't match the message.
+	ExplainNoMatch ExplainDecision = "no match"
+
+	// The trigger didn't match the message, but it was the closest one when
+	// fuzzy matching; see Config.FuzzyDistance.
+	ExplainFuzzy ExplainDecision = "fuzzy match"
+)
+
+/*
+Explain tells which trigger would answer a user's message, and why.
+
+Every trigger in the user's topic is tested against the message in priority
+order, and the result for each one is returned. Unlike `Reply()`, matching
+doesn't stop at the first match, so you can see which other triggers would
+have matched if the selected one wasn't there.
+
+This doesn't change any user data: no reply is generated, the BEGIN block and
+redirects aren't followed, and the message isn't added to the user's history.
+
+Parameters
+
+	username: The name of the user.
+	message: The user's message.
+*/
+func (rs *RiveScript) Explain(username, message string) (*Explanation, error) {
+	if len(rs.sorted.topics) == 0 {
+		return nil, ErrRepliesNotSorted
+	}
+
+	message = rs.formatMessage(message, false)
+	req := rs.newRequest(context.Background(), username, message)
+	explain := &Explanation{
+		Message: message,
+		Stars:   []string{},
+		Steps:   []ExplainStep{},
+	}
+
+	// Find the user's topic, falling back to random like getReply() does.
+	topic := rs.currentTopic(req)
+	if _, ok := rs.topics[topic]; !ok {
+		topic = "random"
+	}
+	if _, ok := rs.topics[topic]; !ok {
+		return nil, &MissingTopicError{Topic: topic}
+	}
+	explain.Topic = topic
+
+	// The bot's last reply, for %Previous.
+	history, err := req.sessions.GetHistory(username)
+	if err != nil {
+		history = sessions.NewHistory()
+	}
+	explain.LastReply = rs.formatMessage(history.Reply[0], true)
+
+	// decide records the outcome of a trigger that passed its tests.
+	decide := func(step *ExplainStep, trigger string, stars []string) {
+		if explain.Trigger == "" {
+			step.Decision = ExplainSelected
+			explain.Trigger = trigger
+			explain.Stars = stars
+		} else {
+			step.Decision = ExplainShadowed
+		}
+	}
+
+	// Triggers with a %Previous.
+	allTopics := []string{topic}
+	if len(rs.includes[topic]) > 0 || len(rs.inherits[topic]) > 0 {
+		allTopics = rs.getTopicTree(topic, 0)
+	}
+	for _, top := range allTopics {
+		for _, trig := range rs.sorted.thats[top] {
+			botside := rs.triggerMatcher(req, trig.pointer.previous)
+			userside := rs.triggerMatcher(req, trig.pointer.trigger)
+			lastReply := explain.LastReply
+			if turn := previousTurn(trig.pointer.previous); turn > 1 {
+				lastReply = rs.formatMessage(history.Reply[turn-1], true)
+			}
+			_, prevOK := botside.match(lastReply)
+			stars, ok := userside.match(message)
+
+			step := ExplainStep{
+				Topic:           trig.pointer.topic,
+				Trigger:         trig.pointer.trigger,
+				Regexp:          fmt.Sprintf("^%s$", userside.source),
+				Previous:        trig.pointer.previous,
+				PreviousRegexp:  fmt.Sprintf("^%s$", botside.source),
+				PreviousMatched: prevOK,
+				Matched:         ok,
+			}
+			switch {
+			case !prevOK:
+				step.Decision = ExplainPreviousFailed
+			case !ok:
+				step.Decision = ExplainNoMatch
+			default:
+				decide(&step, trig.pointer.trigger, stars)
+			}
+			explain.Steps = append(explain.Steps, step)
+		}
+	}
+
+	// The triggers of the topic.
+	fromPrevious := explain.Trigger != ""
+	selected := -1 // The step of the selected trigger of the topic
+	first := len(explain.Steps)
+	for _, trig := range rs.sorted.topics[topic] {
+		matcher := rs.triggerMatcher(req, trig.trigger)
+		stars, ok := matcher.match(message)
+
+		step := ExplainStep{
+			Topic:    trig.pointer.topic,
+			Trigger:  trig.pointer.trigger,
+			Regexp:   fmt.Sprintf("^%s$", matcher.source),
+			Matched:  ok,
+			Decision: ExplainNoMatch,
+		}
+		if ok {
+			decide(&step, trig.pointer.trigger, stars)
+			if step.Decision == ExplainSelected {
+				selected = len(explain.Steps)
+			}
+		}
+		explain.Steps = append(explain.Steps, step)
+	}
+
+	// Fuzzy matching, like getReply() does.
+	if !fromPrevious {
+		if trig, distance, ok := rs.fuzzyFallback(topic, message, explain.Trigger, selected >= 0); ok {
+			for i, candidate := range rs.sorted.topics[topic] {
+				if candidate.pointer != trig.pointer {
+					continue
+				}
+				if selected >= 0 {
+					explain.Steps[selected].Decision = ExplainShadowed
+				}
+				explain.Steps[first+i].Decision = ExplainFuzzy
+				explain.Trigger = trig.trigger
+				explain.Stars = []string{}
+				explain.Fuzzy = true
+				explain.FuzzyDistance = distance
+				break
+			}
+		}
+	}
+
+	return explain, nil
+}
@@ -0,0 +1,126 @@
+package rivescript
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ hello bot
+		- Hello human.
+
+		+ hello *
+		- Hello, <star>.
+
+		+ *
+		- I don't know.
+
+		+ yes
+		% do you like cheese
+		- Me too!
+	`)
+	bot.SortReplies()
+
+	explain, err := bot.Explain("alice", "Hello, bot!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if explain.Message != "hello bot" || explain.Topic != "random" {
+		t.Errorf("got message %q in topic %q", explain.Message, explain.Topic)
+	}
+	if explain.Trigger != "hello bot" {
+		t.Errorf("expected 'hello bot' to be selected, got %q", explain.Trigger)
+	}
+
+	// Every trigger is listed in priority order, %Previous first.
+	decisions := map[string]ExplainDecision{}
+	order := []string{}
+	for _, step := range explain.Steps {
+		decisions[step.Trigger] = step.Decision
+		order = append(order, step.Trigger)
+	}
+	if expect := []string{"yes", "hello bot", "hello *", "*"}; !reflect.DeepEqual(order, expect) {
+		t.Errorf("expected steps %v, got %v", expect, order)
+	}
+	expect := map[string]ExplainDecision{
+		"yes":       ExplainPreviousFailed,
+		"hello bot": ExplainSelected,
+		"hello *":   ExplainShadowed,
+		"*":         ExplainShadowed,
+	}
+	if !reflect.DeepEqual(decisions, expect) {
+		t.Errorf("expected decisions %v, got %v", expect, decisions)
+	}
+	if step := explain.Steps[2]; step.Regexp != "^hello (.+?)$" || !step.Matched {
+		t.Errorf("unexpected step for 'hello *': %+v", step)
+	}
+
+	// Explaining doesn't touch the user's history.
+	if _, err := bot.GetUservars("alice"); err == nil {
+		t.Errorf("Explain() created a profile for the user")
+	}
+
+	// The %Previous is satisfied after the bot asks about cheese.
+	bot.sessions.AddHistory("alice", "hi", "Do you like cheese?")
+	explain, _ = bot.Explain("alice", "yes")
+	if explain.Trigger != "yes" || !explain.Steps[0].PreviousMatched {
+		t.Errorf("expected the %%Previous trigger to be selected: %+v", explain.Steps[0])
+	}
+}
+
+// Explain selects the same trigger that Reply() would match, with fuzzy
+// matching and wildcard classes.
+func TestExplainMatchesReply(t *testing.T) {
+	bot := New(&Config{FuzzyDistance: 2})
+	bot.RegisterWildcardFunc("even", func(value string) bool {
+		n, err := strconv.Atoi(value)
+		return err == nil && n%2 == 0
+	})
+	bot.Stream(`
+		+ hello bot
+		- Hello human.
+
+		+ pick <even>
+		- Even.
+
+		+ pick *
+		- Odd.
+
+		+ *
+		- I don't know.
+	`)
+	bot.SortReplies()
+	bot.SetUservar("alice", "topic", "undefined")
+
+	for _, message := range []string{"hello bot", "helo bot", "pick 4", "pick 5", "something else"} {
+		explain, err := bot.Explain("alice", message)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", message, err)
+		}
+		details, err := bot.ReplyDetailed("alice", message)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", message, err)
+		}
+		if explain.Trigger != details.Trigger || explain.Fuzzy != details.Fuzzy {
+			t.Errorf("%s: Explain() selected %q (fuzzy %v), Reply() matched %q (fuzzy %v)",
+				message, explain.Trigger, explain.Fuzzy, details.Trigger, details.Fuzzy)
+		}
+	}
+
+	explain, _ := bot.Explain("alice", "helo bot")
+	for _, step := range explain.Steps {
+		expect := ExplainNoMatch
+		switch step.Trigger {
+		case "hello bot":
+			expect = ExplainFuzzy
+		case "*":
+			expect = ExplainShadowed
+		}
+		if step.Decision != expect {
+			t.Errorf("%s: expected %q, got %q", step.Trigger, expect, step.Decision)
+		}
+	}
+}
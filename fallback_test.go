@@ -0,0 +1,81 @@
+package rivescript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFallbackReplies(t *testing.T) {
+	newBot := func(cfg *Config) *RiveScript {
+		bot := New(cfg)
+		bot.Stream(`
+			+ hello
+			- Hi! {@nothing here}
+
+			+ play
+			- {topic=game}Let's play.
+
+			> topic game
+				+ quit
+				- {topic=random}Bye.
+			< topic
+		`)
+		bot.SortReplies()
+		return bot
+	}
+
+	// Without fallbacks, nothing has changed.
+	bot := newBot(nil)
+	if _, err := bot.Reply("alice", "xyzzy"); !errors.Is(err, ErrNoTriggerMatched) {
+		t.Errorf("expected ErrNoTriggerMatched, got %v", err)
+	}
+	if reply, _ := bot.Reply("alice", "hello"); reply != "Hi! "+ErrNoTriggerMatched.Error() {
+		t.Errorf("expected the error text from the inline redirect, got %q", reply)
+	}
+
+	// Global and topic fallbacks.
+	bot = newBot(&Config{
+		FallbackReplies: []string{"Sorry <id>, I don't understand.", "Sorry <id>, what?"},
+		TopicFallbackReplies: map[string][]string{
+			"game": {"That's not a move."},
+		},
+		InlineRedirectErrors: InlineRedirectEmpty,
+	})
+	reply, err := bot.Reply("alice", "xyzzy")
+	if err != nil || !strings.HasPrefix(reply, "Sorry alice,") {
+		t.Errorf("expected a global fallback, got %q (%v)", reply, err)
+	}
+	if reply, _ := bot.Reply("alice", "hello"); reply != "Hi! " {
+		t.Errorf("expected the inline redirect to be empty, got %q", reply)
+	}
+	bot.Reply("alice", "play")
+	details, err := bot.ReplyDetailed("alice", "xyzzy")
+	if err != nil || details.Reply != "That's not a move." || !details.Fallback {
+		t.Errorf("expected a topic fallback, got %+v (%v)", details, err)
+	}
+
+	// The no-match hook goes first.
+	bot.AddHook(HookNoMatch, func(req *Request, text string) (string, error) {
+		if text == "help" {
+			return "", ShortCircuit("Try saying quit.")
+		}
+		return text, nil
+	})
+	if reply, _ := bot.Reply("alice", "help"); reply != "Try saying quit." {
+		t.Errorf("expected the no-match hook's reply, got %q", reply)
+	}
+
+	// Inline redirects can use fallbacks or fail the reply.
+	bot = newBot(&Config{
+		FallbackReplies:      []string{"(no idea)"},
+		InlineRedirectErrors: InlineRedirectFallback,
+	})
+	if reply, _ := bot.Reply("alice", "hello"); reply != "Hi! (no idea)" {
+		t.Errorf("expected a fallback for the inline redirect, got %q", reply)
+	}
+	bot = newBot(&Config{InlineRedirectErrors: InlineRedirectError})
+	if _, err := bot.Reply("alice", "hello"); !errors.Is(err, ErrNoTriggerMatched) {
+		t.Errorf("expected the inline redirect's error, got %v", err)
+	}
+}
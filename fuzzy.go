@@ -0,0 +1,201 @@
+package rivescript
+
+// Fuzzy matching for messages with typos in them.
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxFuzzyVariants caps how many messages a trigger with optionals can be
+// expanded into for fuzzy matching. Triggers with more are left out.
+const maxFuzzyVariants = 64
+
+// fuzzyCandidate is a trigger that a message can be fuzzy matched to.
+type fuzzyCandidate struct {
+	pos      int      // The position of the trigger in its sorted topic
+	variants []string // The messages that the trigger matches
+	distance int      // Its own {fuzzy} threshold, or -1 to use the bot's
+}
+
+// fuzzyEnabled tells whether fuzzy matching is turned on for a topic: for the
+// whole bot, or by a {fuzzy} tag on one of its triggers.
+func (rs *RiveScript) fuzzyEnabled(triggers []sortedTriggerEntry) bool {
+	if rs.fuzzyDistance > 0 {
+		return true
+	}
+	for _, trig := range triggers {
+		if strings.Contains(trig.trigger, "{fuzzy=") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+newFuzzyCandidates finds the triggers of a sorted topic that can be fuzzy
+matched: the ones that are atomic, or that only have optionals, which are
+expanded into all of the messages they match.
+*/
+func (rs *RiveScript) newFuzzyCandidates(triggers []sortedTriggerEntry) []fuzzyCandidate {
+	candidates := []fuzzyCandidate{}
+	for i, trig := range triggers {
+		variants, ok := fuzzyVariants(trig.trigger)
+		if !ok {
+			continue
+		}
+
+		distance := -1
+		if match := reFuzzy.FindStringSubmatch(trig.trigger); len(match) > 0 {
+			distance, _ = strconv.Atoi(match[1])
+		}
+		candidates = append(candidates, fuzzyCandidate{
+			pos:      i,
+			variants: variants,
+			distance: distance,
+		})
+	}
+	return candidates
+}
+
+// fuzzyVariants expands a trigger pattern into the messages that it matches,
+// if it has no wildcards, alternations, arrays or tags.
+func fuzzyVariants(pattern string) ([]string, bool) {
+	pattern = stripTriggerTags(pattern)
+	if strings.ContainsAny(pattern, "*#_()@<>{}") {
+		return nil, false
+	}
+
+	// Expand the first optional of every variant until none are left. The
+	// options have different lengths, so each variant is searched again.
+	variants := []string{pattern}
+	for expanding := true; expanding; {
+		expanding = false
+		expanded := []string{}
+		for _, variant := range variants {
+			match := reOptional.FindStringSubmatchIndex(variant)
+			if len(match) == 0 {
+				expanded = append(expanded, variant)
+				continue
+			}
+
+			expanding = true
+			before, after := variant[:match[0]], variant[match[1]:]
+			options := append(strings.Split(variant[match[2]:match[3]], "|"), "")
+			for _, option := range options {
+				expanded = append(expanded, before+" "+option+" "+after)
+			}
+		}
+		if len(expanded) > maxFuzzyVariants {
+			return nil, false
+		}
+		variants = expanded
+	}
+
+	for i, variant := range variants {
+		variants[i] = strings.Join(strings.Fields(variant), " ")
+	}
+	return variants, true
+}
+
+/*
+fuzzyFallback tries fuzzy matching for a message that matched no trigger, or
+only a catch-all trigger like `*`. It's given the trigger that matched, if
+any.
+*/
+func (rs *RiveScript) fuzzyFallback(topic, message, matched string, found bool) (sortedTriggerEntry, int, bool) {
+	if found && !isCatchAll(matched) {
+		return sortedTriggerEntry{}, -1, false
+	}
+	return rs.fuzzyMatch(topic, message)
+}
+
+/*
+fuzzyMatch finds the trigger of a topic that's the closest to the message, by
+the edit distance between them.
+
+A trigger is only picked if the distance is within its threshold, and it's
+less than half of the length of the trigger, so that short triggers aren't
+matched by messages that have hardly anything in common with them. Of the
+triggers that are equally close, the one that sorts first is picked.
+*/
+func (rs *RiveScript) fuzzyMatch(topic, message string) (sortedTriggerEntry, int, bool) {
+	var (
+		best     sortedTriggerEntry
+		bestDist = -1
+	)
+
+	triggers := rs.sorted.topics[topic]
+	for _, candidate := range rs.sorted.fuzzy[topic] {
+		threshold := candidate.distance
+		if threshold < 0 {
+			threshold = rs.fuzzyDistance
+		}
+		if threshold <= 0 {
+			continue
+		}
+
+		for _, variant := range candidate.variants {
+			length := len([]rune(variant))
+			if diff := len([]rune(message)) - length; diff > threshold || -diff > threshold {
+				continue
+			}
+
+			distance := editDistance(message, variant)
+			if distance <= threshold && distance*2 < length && (bestDist < 0 || distance < bestDist) {
+				best, bestDist = triggers[candidate.pos], distance
+			}
+		}
+	}
+
+	return best, bestDist, bestDist >= 0
+}
+
+// editDistance is the Levenshtein distance between two strings: the number
+// of letters that have to be added, removed or changed to turn one into the
+// other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			// Remove a letter, add one, or change one.
+			curr[j] = prev[j] + 1
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if prev[j-1]+cost < curr[j] {
+				curr[j] = prev[j-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// isCatchAll tells whether a trigger pattern has no words of its own, like `*`.
+func isCatchAll(pattern string) bool {
+	pattern = reNamedStar.ReplaceAllString(stripTriggerTags(pattern), "$1")
+	return wordCount(pattern, false) == 0
+}
+
+// stripTriggerTags removes the tags that set options on a trigger, like
+// {weight} and {select}, from its pattern.
+func stripTriggerTags(pattern string) string {
+	pattern = reWeight.ReplaceAllString(pattern, " ")
+	pattern = reInherits.ReplaceAllString(pattern, "")
+	pattern = reSelect.ReplaceAllString(pattern, "")
+	pattern = reFuzzy.ReplaceAllString(pattern, "")
+	return strings.TrimSpace(pattern)
+}
@@ -0,0 +1,173 @@
+package rivescript
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatching(t *testing.T) {
+	bot := New(&Config{FuzzyDistance: 2})
+	bot.Stream(`
+		+ hello bot
+		- Hello, human!
+
+		+ what is your name
+		- I'm a bot.
+
+		+ [please] tell me a (joke|story)
+		- Once upon a time...
+
+		+ [please] tell me a joke
+		- Knock knock.
+
+		+ how old are you {fuzzy=0}
+		- I'm ageless.
+
+		+ hi
+		- Hi there!
+
+		+ my name is *
+		- Nice to meet you, <star>.
+
+		+ *
+		- I don't understand.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message  string
+		reply    string
+		distance int // -1 if it's not a fuzzy match
+	}{
+		{"hello bot", "Hello, human!", -1},
+		{"helo bot", "Hello, human!", 1},
+		{"what is yuor name", "I'm a bot.", 2},
+		{"whats is yur nmae", "I don't understand.", -1},
+		{"please tell me a jok", "Knock knock.", 1},
+		{"tell me a joek", "Knock knock.", 2},
+		{"how old are yu", "I don't understand.", -1},
+		{"ho", "I don't understand.", -1},
+		{"my name is bob", "Nice to meet you, bob.", -1},
+	}
+	for _, test := range tests {
+		details, err := bot.ReplyDetailed("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+			continue
+		}
+		if details.Reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.message, test.reply, details.Reply)
+		}
+		if fuzzy := test.distance >= 0; details.Fuzzy != fuzzy {
+			t.Errorf("%s: expected Fuzzy to be %v", test.message, fuzzy)
+		} else if fuzzy && details.FuzzyDistance != test.distance {
+			t.Errorf("%s: expected a distance of %d, got %d", test.message, test.distance, details.FuzzyDistance)
+		}
+	}
+}
+
+func TestFuzzyTriggerDistance(t *testing.T) {
+	// Fuzzy matching is off for the bot, but a trigger can turn it on.
+	bot := New(&Config{})
+	bot.Stream(`
+		+ good morning {fuzzy=1}
+		- Good morning to you!
+
+		+ good night
+		- Sleep well.
+	`)
+	bot.SortReplies()
+
+	if reply, _ := bot.Reply("alice", "good mornin"); reply != "Good morning to you!" {
+		t.Errorf("expected a fuzzy match, got %q", reply)
+	}
+	if _, err := bot.Reply("alice", "good morni"); err == nil {
+		t.Errorf("expected no match for a distance of 2")
+	}
+	if _, err := bot.Reply("alice", "good nite"); err == nil {
+		t.Errorf("expected no fuzzy match without a {fuzzy} tag")
+	}
+
+	// Topics without any {fuzzy} tags don't get fuzzy candidates at all.
+	bot.Stream(`
+		> topic quiet
+			+ [please] tell me a [funny] joke
+			- Knock knock.
+		< topic
+	`)
+	bot.SortReplies()
+	if _, ok := bot.sorted.fuzzy["quiet"]; ok {
+		t.Errorf("expected no fuzzy candidates for a topic without {fuzzy} tags")
+	}
+	if len(bot.sorted.fuzzy["random"]) == 0 {
+		t.Errorf("expected fuzzy candidates for the topic with a {fuzzy} tag")
+	}
+}
+
+func TestFuzzyVariants(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		variants []string
+	}{
+		{"hello bot", []string{"hello bot"}},
+		{"hello bot {weight=10}{fuzzy=3}", []string{"hello bot"}},
+		{"[please] help", []string{"please help", "help"}},
+		{"tell me [a|the] joke", []string{"tell me a joke", "tell me the joke", "tell me joke"}},
+		{"[please] tell me a [funny] joke", []string{
+			"please tell me a funny joke", "please tell me a joke",
+			"tell me a funny joke", "tell me a joke",
+		}},
+		{"[oh] [hi|hey] there [friend]", []string{
+			"oh hi there friend", "oh hi there", "oh hey there friend", "oh hey there",
+			"oh there friend", "oh there", "hi there friend", "hi there",
+			"hey there friend", "hey there", "there friend", "there",
+		}},
+		{"hello *", nil},
+		{"what is (your|my) name", nil},
+		{"i like @colors", nil},
+		{"my email is <email>", nil},
+	}
+	for _, test := range tests {
+		variants, ok := fuzzyVariants(test.pattern)
+		if ok != (test.variants != nil) || !reflect.DeepEqual(variants, test.variants) {
+			t.Errorf("%s: expected %v, got %v", test.pattern, test.variants, variants)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"hello", "hello", 0},
+		{"hello", "", 5},
+		{"kitten", "sitting", 3},
+		{"helo", "hello", 1},
+		{"yuor", "your", 2},
+		{"café", "cafe", 1},
+	}
+	for _, test := range tests {
+		if distance := editDistance(test.a, test.b); distance != test.distance {
+			t.Errorf("%s/%s: expected %d, got %d", test.a, test.b, test.distance, distance)
+		}
+	}
+}
+
+// Triggers with several optionals are expanded for fuzzy matching, and don't
+// break sorting.
+func TestFuzzySeveralOptionals(t *testing.T) {
+	bot := New(&Config{FuzzyDistance: 2})
+	bot.Stream(`
+		+ [please] tell me a [funny] joke
+		- Knock knock.
+	`)
+	bot.SortReplies()
+
+	for _, message := range []string{"please tell me a funy joke", "tell me a joek"} {
+		if reply, err := bot.Reply("alice", message); err != nil || reply != "Knock knock." {
+			t.Errorf("%s: expected a fuzzy match, got %q (%v)", message, reply, err)
+		}
+	}
+}
@@ -3,8 +3,8 @@ module github.com/aichaos/rivescript-go
 go 1.16
 
 require (
-	github.com/dop251/goja v0.0.0-20230812105242-81d76064690d // indirect
-	github.com/mattn/go-shellwords v1.0.12 // indirect
+	github.com/dop251/goja v0.0.0-20230812105242-81d76064690d
+	github.com/mattn/go-shellwords v1.0.12
 	github.com/onsi/gomega v1.15.0 // indirect
 	github.com/robertkrimen/otto v0.2.1
 	golang.org/x/text v0.12.0 // indirect
@@ -0,0 +1,124 @@
+package rivescript
+
+// Hooks around the reply pipeline.
+
+import "errors"
+
+// HookStage is a point in the reply pipeline where hooks are run.
+type HookStage int
+
+// The stages of the reply pipeline.
+const (
+	// HookBeforeFormat hooks get the user's message as it was given to
+	// `Reply()`, before it was formatted for matching.
+	HookBeforeFormat HookStage = iota
+
+	// HookAfterFormat hooks get the user's message after it was formatted,
+	// just before it's matched to a trigger.
+	HookAfterFormat
+
+	// HookAfterReply hooks get the bot's reply, before it's saved to the
+	// user's history and returned.
+	HookAfterReply
+
+	// HookNoMatch hooks get the user's message when no reply was found for
+	// it. A hook may answer it by returning `ShortCircuit(reply)`; otherwise
+	// the fallback replies from the Config are used, if there are any.
+	HookNoMatch
+)
+
+/*
+HookFunc is a function prototype for hooks in the reply pipeline.
+
+A hook gets the request and the text for its stage (the user's message or the
+bot's reply), and returns the text to continue with. It may return an error
+from `ShortCircuit()` to answer the message with its own reply, or any other
+error to abort the reply, in which case `Reply()` returns that error.
+*/
+type HookFunc func(req *Request, text string) (string, error)
+
+/*
+AddHook adds a hook to the reply pipeline.
+
+Hooks for a stage run in the order they were added, and each one gets the
+text returned by the one before it. If a hook before matching (at
+HookBeforeFormat or HookAfterFormat) short-circuits, the bot doesn't look for
+a reply and the hook's reply is used instead. It still goes through the
+HookAfterReply hooks and is saved to the user's history.
+
+HookAfterReply hooks may also change the `Message` of the request, which is
+the message that's saved to the user's history, for example to redact it.
+
+Parameters
+
+	stage: The stage of the pipeline to run the hook at.
+	fn: A function with a prototype `func(*Request, string) (string, error)`
+*/
+func (rs *RiveScript) AddHook(stage HookStage, fn HookFunc) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.hooks[stage] = append(rs.hooks[stage], fn)
+}
+
+/*
+ClearHooks removes all of the hooks at a stage of the reply pipeline.
+
+Parameters
+
+	stage: The stage of the pipeline.
+*/
+func (rs *RiveScript) ClearHooks(stage HookStage) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	delete(rs.hooks, stage)
+}
+
+// shortCircuit is the error returned by ShortCircuit().
+type shortCircuit struct {
+	reply string
+}
+
+func (e *shortCircuit) Error() string {
+	return "hook short-circuited the reply"
+}
+
+/*
+ShortCircuit is returned by a hook to answer the message with its own reply.
+
+	bot.AddHook(rivescript.HookAfterFormat, func(req *rivescript.Request, text string) (string, error) {
+		if isRude(text) {
+			return "", rivescript.ShortCircuit("Please be nice.")
+		}
+		return text, nil
+	})
+*/
+func ShortCircuit(reply string) error {
+	return &shortCircuit{reply: reply}
+}
+
+/*
+runHooks runs the hooks of a stage on the text.
+
+It returns the resulting text. If a hook short-circuited, its reply is
+returned instead and the boolean is true, and no more hooks are run.
+*/
+func (rs *RiveScript) runHooks(req *Request, stage HookStage, text string) (string, bool, error) {
+	rs.cLock.Lock()
+	hooks := rs.hooks[stage]
+	rs.cLock.Unlock()
+
+	for _, hook := range hooks {
+		result, err := hook(req, text)
+		if err != nil {
+			var sc *shortCircuit
+			if errors.As(err, &sc) {
+				return sc.reply, true, nil
+			}
+			return "", false, err
+		}
+		text = result
+	}
+	return text, false, nil
+}
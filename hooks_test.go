@@ -0,0 +1,80 @@
+package rivescript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ hello
+		- Hi <b>there</b>!
+
+		+ my number is *
+		- I'll remember <star>.
+
+		+ what did i say
+		- You said: <input1>
+	`)
+	bot.SortReplies()
+
+	// Profanity filter: short-circuit before matching.
+	bot.AddHook(HookAfterFormat, func(req *Request, text string) (string, error) {
+		if strings.Contains(text, "darn") {
+			return "", ShortCircuit("Please be nice.")
+		}
+		return text, nil
+	})
+
+	// Rewrite the raw message before it's formatted.
+	bot.AddHook(HookBeforeFormat, func(req *Request, text string) (string, error) {
+		return strings.Replace(text, "Howdy", "hello", -1), nil
+	})
+
+	// Abort the reply.
+	errBlocked := errors.New("blocked")
+	bot.AddHook(HookBeforeFormat, func(req *Request, text string) (string, error) {
+		if req.Username == "mallory" {
+			return "", errBlocked
+		}
+		return text, nil
+	})
+
+	// Escape the output and redact the history.
+	bot.AddHook(HookAfterReply, func(req *Request, text string) (string, error) {
+		if strings.HasPrefix(req.Message, "my number is") {
+			req.Message = "my number is [redacted]"
+		}
+		return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text), nil
+	})
+
+	tests := []struct {
+		message string
+		expect  string
+	}{
+		{"Howdy!", "Hi &lt;b&gt;there&lt;/b&gt;!"},
+		{"well darn it", "Please be nice."},
+		{"what did i say", "You said: well darn it"},
+		{"my number is 5551234", "I'll remember 5551234."},
+		{"what did i say", "You said: my number is [redacted]"},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.message, err)
+		} else if reply != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.message, test.expect, reply)
+		}
+	}
+
+	if _, err := bot.Reply("mallory", "hello"); err != errBlocked {
+		t.Errorf("expected the hook's error, got %v", err)
+	}
+
+	bot.ClearHooks(HookAfterReply)
+	if reply, _ := bot.Reply("alice", "hello"); reply != "Hi <b>there</b>!" {
+		t.Errorf("expected the after-reply hooks to be cleared, got %q", reply)
+	}
+}
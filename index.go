@@ -0,0 +1,151 @@
+package rivescript
+
+// Trigger matching index.
+
+import (
+	"regexp"
+)
+
+/*
+triggerIndex narrows down which triggers of a topic could match a message.
+
+Every trigger in the sorted list of a topic goes into exactly one bucket:
+
+  - atomic: triggers that match only one exact message, keyed by it.
+  - words: triggers that begin with a literal word, keyed by that word.
+  - unindexed: everything else, which always has to be tried.
+
+A bucket holds positions into the sorted list. The candidates for a message
+are merged back into sort order, so the first one that matches is the same
+trigger that a linear scan of the whole list would have found.
+*/
+type triggerIndex struct {
+	atomic    map[string]int   // Exact message -> first trigger position
+	words     map[string][]int // First word -> trigger positions
+	unindexed []int
+}
+
+// newTriggerIndex indexes the sorted triggers of a topic.
+func (rs *RiveScript) newTriggerIndex(triggers []sortedTriggerEntry) *triggerIndex {
+	idx := &triggerIndex{
+		atomic: map[string]int{},
+		words:  map[string][]int{},
+	}
+
+	for i, trig := range triggers {
+		pattern := trig.trigger
+
+		// Atomic triggers whose regexp is the literal message itself.
+		if !isDynamicPattern(pattern) {
+			matcher := rs.triggerMatcher(nil, pattern)
+			if matcher.atomic && regexp.QuoteMeta(matcher.source) == matcher.source {
+				if _, ok := idx.atomic[matcher.source]; !ok {
+					idx.atomic[matcher.source] = i
+				}
+				continue
+			}
+		}
+
+		// Triggers that start with a literal word.
+		if word, ok := patternFirstWord(pattern); ok {
+			idx.words[word] = append(idx.words[word], i)
+			continue
+		}
+
+		idx.unindexed = append(idx.unindexed, i)
+	}
+
+	return idx
+}
+
+// candidates returns the positions of the triggers that might match the
+// message, in sort order.
+func (idx *triggerIndex) candidates(message string) []int {
+	var atomic []int
+	if i, ok := idx.atomic[message]; ok {
+		atomic = []int{i}
+	}
+	return mergePositions(atomic, idx.words[messageFirstWord(message)], idx.unindexed)
+}
+
+// mergePositions merges sorted lists of trigger positions into one.
+func mergePositions(lists ...[]int) []int {
+	size := 0
+	for _, list := range lists {
+		size += len(list)
+	}
+
+	result := make([]int, 0, size)
+	heads := make([]int, len(lists))
+	for len(result) < size {
+		best := -1
+		for i, list := range lists {
+			if heads[i] < len(list) && (best == -1 || list[heads[i]] < lists[best][heads[best]]) {
+				best = i
+			}
+		}
+		result = append(result, lists[best][heads[best]])
+		heads[best]++
+	}
+	return result
+}
+
+/*
+patternFirstWord gets the literal word that a trigger pattern starts with.
+
+The word must be followed by a space, an optional or the end of the pattern,
+so that any message the trigger matches starts with the very same word. A
+pattern with a top-level `|` alternation can match messages that don't start
+with its first word at all, so those aren't indexed.
+*/
+func patternFirstWord(pattern string) (string, bool) {
+	pattern = reWeight.ReplaceAllString(pattern, "")
+	pattern = reInherits.ReplaceAllString(pattern, "")
+	pattern = reSelect.ReplaceAllString(pattern, "")
+	pattern = reFuzzy.ReplaceAllString(pattern, "")
+
+	var depth int
+	for _, char := range pattern {
+		switch char {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case '|':
+			if depth <= 0 {
+				return "", false
+			}
+		}
+	}
+
+	end := 0
+	for end < len(pattern) && isLowerWordChar(pattern[end]) {
+		end++
+	}
+	if end == 0 {
+		return "", false
+	}
+	if end < len(pattern) && pattern[end] != ' ' && pattern[end] != '[' {
+		return "", false
+	}
+	return pattern[:end], true
+}
+
+// messageFirstWord gets the leading run of regexp word characters (`\w`) of
+// a message.
+func messageFirstWord(message string) string {
+	end := 0
+	for end < len(message) {
+		c := message[end]
+		if !isLowerWordChar(c) && !(c >= 'A' && c <= 'Z') && c != '_' {
+			break
+		}
+		end++
+	}
+	return message[:end]
+}
+
+// isLowerWordChar tells whether a byte is a lowercase ASCII letter or digit.
+func isLowerWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
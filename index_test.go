@@ -0,0 +1,82 @@
+package rivescript
+
+import "testing"
+
+// The trigger index must pick the same trigger as a linear scan would.
+func TestTriggerIndex(t *testing.T) {
+	bot := New(nil)
+	bot.Quiet = true
+	if err := bot.LoadDirectory("eg/brain"); err != nil {
+		t.Fatal(err)
+	}
+	err := bot.Stream(`
+		! array colors = red green blue
+
+		+ hello bot
+		- Atomic.
+
+		+ hello [there] bot
+		- Optional after the first word.
+
+		+ hello *
+		- Wildcard after the first word.
+
+		+ hello|howdy partner
+		- Top-level alternation.
+
+		+ (what|who) is *
+		- Alternation first.
+
+		+ i like @colors
+		- Array.
+
+		+ hello_
+		- Underscore wildcard.
+
+		+ 123 go
+		- Digits.
+
+		+ {weight=10}weighted trigger
+		- Weighted.
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.SortReplies(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{
+		"hello bot", "hello there bot", "hello", "hello world", "hellothere",
+		"hello partner", "howdy partner", "say hello partner", "what is it",
+		"who is there", "i like red", "i like", "hello_world", "123 go",
+		"weighted trigger", "my name is bob", "hi", "", "how are you",
+		"who are you", "i feel sad", "bye", "x",
+	}
+
+	for topic, triggers := range bot.sorted.topics {
+		idx := bot.sorted.index[topic]
+		for _, message := range messages {
+			want := -1
+			for i, trig := range triggers {
+				if _, ok := bot.triggerMatcher(nil, trig.trigger).match(message); ok {
+					want = i
+					break
+				}
+			}
+
+			got := -1
+			for _, i := range idx.candidates(message) {
+				if _, ok := bot.triggerMatcher(nil, triggers[i].trigger).match(message); ok {
+					got = i
+					break
+				}
+			}
+
+			if got != want {
+				t.Errorf("topic %s, message %q: index matched trigger #%d, expected #%d",
+					topic, message, got, want)
+			}
+		}
+	}
+}
@@ -48,6 +48,7 @@ And in your RiveScript code, you can load and run JavaScript objects:
 package javascript
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -59,6 +60,46 @@ type JavaScriptHandler struct {
 	VM        *goja.Runtime
 	bot       *rivescript.RiveScript
 	functions map[string]string
+	lock      chan struct{} // The VM runs one macro at a time.
+}
+
+// lockKey marks a context whose request is running a macro in the VM, so a
+// reply requested from inside the macro can call macros of its own.
+type lockKey struct {
+	lock chan struct{}
+}
+
+/*
+macroBot is the `rs` object given to a JavaScript macro. It's the bot, except
+that `CurrentUser()` and `CurrentRequest()` answer for the request that called
+the macro, and `Reply()` carries the macro's context so that a nested reply
+can call macros too.
+*/
+type macroBot struct {
+	*rivescript.RiveScript
+	ctx context.Context
+}
+
+// CurrentUser returns the ID of the user who called the macro.
+func (b *macroBot) CurrentUser() (string, error) {
+	req, err := b.CurrentRequest()
+	if err != nil {
+		return "", err
+	}
+	return req.Username, nil
+}
+
+// CurrentRequest returns the state of the request that called the macro.
+func (b *macroBot) CurrentRequest() (*rivescript.Request, error) {
+	if req, ok := rivescript.RequestFromContext(b.ctx); ok {
+		return req, nil
+	}
+	return b.RiveScript.CurrentRequest()
+}
+
+// Reply fetches a reply from inside the macro.
+func (b *macroBot) Reply(username, message string) (string, error) {
+	return b.ReplyContext(b.ctx, username, message)
 }
 
 // New creates an object handler for JavaScript with its own Otto VM.
@@ -67,6 +108,7 @@ func New(rs *rivescript.RiveScript) *JavaScriptHandler {
 	js.VM = goja.New()
 	js.bot = rs
 	js.functions = map[string]string{}
+	js.lock = make(chan struct{}, 1)
 
 	return js
 }
@@ -86,8 +128,36 @@ func (js JavaScriptHandler) Load(name string, code []string) {
 
 // Call executes a JavaScript macro and returns its results.
 func (js JavaScriptHandler) Call(name string, fields []string) string {
+	reply, err := js.CallContext(context.Background(), name, fields)
+	if err != nil {
+		fmt.Printf("Error: %s", err)
+	}
+	return reply
+}
+
+/*
+CallContext executes a JavaScript macro and returns its results. If the
+context is cancelled while the script is running, the script is interrupted
+and the context's error is returned.
+
+The VM isn't safe for concurrent use, so macros run one at a time, and a call
+waits for its turn only as long as its context allows. A reply requested from
+inside a macro with `rs.Reply()` may call macros without waiting.
+*/
+func (js JavaScriptHandler) CallContext(ctx context.Context, name string, fields []string) (string, error) {
+	key := lockKey{js.lock}
+	if ctx.Value(key) == nil {
+		select {
+		case js.lock <- struct{}{}:
+			defer func() { <-js.lock }()
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		ctx = context.WithValue(ctx, key, true)
+	}
+
 	// Make the RiveScript object available to the JS.
-	v := js.VM.ToValue(js.bot)
+	v := js.VM.ToValue(&macroBot{RiveScript: js.bot, ctx: ctx})
 
 	// Convert the fields into a JavaScript object.
 	jsFields := js.VM.ToValue(fields)
@@ -95,19 +165,40 @@ func (js JavaScriptHandler) Call(name string, fields []string) string {
 	// Run the JS function call and get the result.
 	function, ok := goja.AssertFunction(js.VM.Get(fmt.Sprintf("object_%s", name)))
 	if !ok {
-		return fmt.Sprintf("[goja: error asserting function object_%s]", name)
+		return fmt.Sprintf("[goja: error asserting function object_%s]", name), nil
 	}
 
+	// Interrupt the VM if the request is cancelled mid-script.
+	done := make(chan struct{})
+	watcher := make(chan struct{})
+	go func() {
+		defer close(watcher)
+		select {
+		case <-ctx.Done():
+			js.VM.Interrupt(ctx.Err())
+		case <-done:
+		}
+	}()
+
 	result, err := function(goja.Undefined(), v, jsFields)
+
+	// Make sure a late interrupt can't leak into the next macro call.
+	close(done)
+	<-watcher
+	js.VM.ClearInterrupt()
+
 	if err != nil {
-		fmt.Printf("Error: %s", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", err
 	}
 
 	reply := ""
-	if !goja.IsUndefined(result) {
+	if result != nil && !goja.IsUndefined(result) {
 		reply = result.String()
 	}
 
 	// Return it.
-	return reply
+	return reply, nil
 }
@@ -1,6 +1,8 @@
 // Package macros exports types relevant to object macros.
 package macro
 
+import "context"
+
 // MacroInterface is the interface for a Go object macro handler.
 //
 // Here, "object macro handler" means Go code is handling object macros for a
@@ -9,3 +11,14 @@ type MacroInterface interface {
 	Load(name string, code []string)
 	Call(name string, fields []string) string
 }
+
+// ContextMacroInterface is an optional interface for object macro handlers
+// that can be cancelled.
+//
+// If a handler implements it, RiveScript calls `CallContext()` instead of
+// `Call()` and passes the context of the reply that invoked the macro. The
+// handler should stop early and return an error if the context is done.
+type ContextMacroInterface interface {
+	MacroInterface
+	CallContext(ctx context.Context, name string, fields []string) (string, error)
+}
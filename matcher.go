@@ -0,0 +1,223 @@
+package rivescript
+
+// Compiled trigger matchers.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxDynamicMatchers caps the cache of triggers that interpolate variables,
+// whose regexps can differ from one user (or one message) to the next.
+const maxDynamicMatchers = 1024
+
+// triggerMatcher is a trigger pattern that's ready to match messages.
+type triggerMatcher struct {
+	source string         // The regexp source from triggerRegexp()
+	atomic bool           // Whether source can be compared to the message as-is
+	re     *regexp.Regexp // The compiled regexp; nil if it failed to compile
+
+	// The names of the stars, if they have any, and the functions that check
+	// the stars captured by wildcard classes, by the positions of the stars.
+	names      []string
+	validators map[int]func(string) bool
+}
+
+// match tests a message against the trigger and returns the captured stars.
+func (m *triggerMatcher) match(message string) ([]string, bool) {
+	if m.atomic && message == m.source {
+		return []string{}, true
+	}
+	if m.re == nil {
+		return nil, false
+	}
+
+	match := m.re.FindStringSubmatch(message)
+	if len(match) == 0 {
+		return nil, false
+	}
+
+	stars := []string{}
+	if len(match) > 1 {
+		stars = append(stars, match[1:]...)
+	}
+	for i, valid := range m.validators {
+		if !valid(stars[i]) {
+			return nil, false
+		}
+	}
+	return stars, true
+}
+
+// named gets the stars that were captured by named wildcards, like `*{name}`.
+func (m *triggerMatcher) named(stars []string) map[string]string {
+	named := map[string]string{}
+	for i, name := range m.names {
+		if name != "" && i < len(stars) {
+			named[name] = stars[i]
+		}
+	}
+	return named
+}
+
+/*
+isDynamicPattern tells whether a trigger pattern interpolates variables.
+
+Triggers with `<get>`, `<input>` or `<reply>` depend on the user, and `<bot>`
+variables can change at any time, so these can't be compiled ahead of time.
+*/
+func isDynamicPattern(pattern string) bool {
+	return strings.Contains(pattern, "<get ") ||
+		strings.Contains(pattern, "<input") ||
+		strings.Contains(pattern, "<reply") ||
+		strings.Contains(pattern, "<bot ")
+}
+
+// newTriggerMatcher compiles a regexp source from triggerRegexp().
+func (rs *RiveScript) newTriggerMatcher(pattern, source string) *triggerMatcher {
+	m := &triggerMatcher{
+		source: source,
+		atomic: isAtomic(pattern),
+	}
+
+	compiled, err := regexp.Compile(fmt.Sprintf("^%s$", source))
+	if err != nil {
+		rs.warn("Couldn't compile trigger '%s' into a regexp: %s", pattern, err)
+		return m
+	}
+	m.re = compiled
+
+	// Find the names of the stars, and the wildcard classes that captured
+	// them.
+	var classes map[string]wildcardClass
+	for i, group := range compiled.SubexpNames()[1:] {
+		if group == "" {
+			m.names = append(m.names, "")
+			continue
+		}
+		if classes == nil {
+			classes = rs.wildcardClasses()
+		}
+
+		name, class := parseWildcardGroup(group, classes)
+		m.names = append(m.names, name)
+		if valid := classes[class].valid; class != "" && valid != nil {
+			if m.validators == nil {
+				m.validators = map[int]func(string) bool{}
+			}
+			m.validators[i] = valid
+		}
+	}
+	return m
+}
+
+/*
+triggerMatcher gets the matcher for a trigger pattern.
+
+Static patterns are compiled once, by SortReplies() or else on first use.
+Dynamic patterns are expanded for the current request every time, and their
+compiled regexps are cached by their expanded source.
+*/
+func (rs *RiveScript) triggerMatcher(req *Request, pattern string) *triggerMatcher {
+	sb := rs.sorted
+	dynamic := isDynamicPattern(pattern)
+
+	if !dynamic {
+		sb.matcherLock.RLock()
+		m, ok := sb.matchers[pattern]
+		sb.matcherLock.RUnlock()
+		if ok {
+			return m
+		}
+
+		m = rs.newTriggerMatcher(pattern, rs.triggerRegexp(req, pattern))
+		sb.matcherLock.Lock()
+		if sb.matchers == nil {
+			sb.matchers = map[string]*triggerMatcher{}
+		}
+		sb.matchers[pattern] = m
+		sb.matcherLock.Unlock()
+		return m
+	}
+
+	source := rs.triggerRegexp(req, pattern)
+	sb.matcherLock.RLock()
+	m, ok := sb.dynamic[source]
+	sb.matcherLock.RUnlock()
+	if ok {
+		return m
+	}
+
+	m = rs.newTriggerMatcher(pattern, source)
+	sb.matcherLock.Lock()
+	if sb.dynamic == nil || len(sb.dynamic) >= maxDynamicMatchers {
+		sb.dynamic = map[string]*triggerMatcher{}
+	}
+	sb.dynamic[source] = m
+	sb.matcherLock.Unlock()
+	return m
+}
+
+// compileTriggers compiles the static triggers and the substitutions of the
+// sort buffers ahead of time, so that the first replies don't pay for it.
+func (rs *RiveScript) compileTriggers() {
+	rs.sorted.matcherLock.Lock()
+	rs.sorted.matchers = map[string]*triggerMatcher{}
+	rs.sorted.dynamic = map[string]*triggerMatcher{}
+	rs.sorted.substitutions = map[string][4]*regexp.Regexp{}
+	rs.sorted.matcherLock.Unlock()
+
+	for _, pattern := range rs.sorted.sub {
+		rs.substitutionRegexps(pattern)
+	}
+	for _, pattern := range rs.sorted.person {
+		rs.substitutionRegexps(pattern)
+	}
+
+	compile := func(pattern string) {
+		if pattern != "" && !isDynamicPattern(pattern) {
+			rs.triggerMatcher(nil, pattern)
+		}
+	}
+
+	for _, triggers := range rs.sorted.topics {
+		for _, trig := range triggers {
+			compile(trig.trigger)
+		}
+	}
+	for _, triggers := range rs.sorted.thats {
+		for _, trig := range triggers {
+			compile(trig.pointer.trigger)
+			compile(trig.pointer.previous)
+		}
+	}
+}
+
+// substitutionRegexps gets the compiled regexps for a substitution pattern:
+// the pattern as the whole message, at its start, in its middle and at its end.
+func (rs *RiveScript) substitutionRegexps(pattern string) [4]*regexp.Regexp {
+	sb := rs.sorted
+	sb.matcherLock.RLock()
+	re, ok := sb.substitutions[pattern]
+	sb.matcherLock.RUnlock()
+	if ok {
+		return re
+	}
+
+	qm := quotemeta(pattern)
+	re = [4]*regexp.Regexp{
+		regexp.MustCompile(fmt.Sprintf(`^%s$`, qm)),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\W+)`, qm)),
+		regexp.MustCompile(fmt.Sprintf(`(\W+)%s(\W+)`, qm)),
+		regexp.MustCompile(fmt.Sprintf(`(\W+)%s$`, qm)),
+	}
+
+	sb.matcherLock.Lock()
+	if sb.substitutions == nil {
+		sb.substitutions = map[string][4]*regexp.Regexp{}
+	}
+	sb.substitutions[pattern] = re
+	sb.matcherLock.Unlock()
+	return re
+}
@@ -0,0 +1,77 @@
+package rivescript
+
+import "testing"
+
+func TestMathTags(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ set * to *
+		- <set <star1>=<star2>>Set <star1> to <get <star1>>.
+
+		+ * *
+		- <<star1> n=<star2>>n is <get n>.
+
+		+ add a quarter
+		- <add n=0.25>n is <get n>.
+
+		+ take half
+		- <sub n=0.5>n is <get n>.
+
+		+ score *
+		- <add score=<star> min=0 max=100>Score: <get score>.
+
+		+ lose
+		- <sub score=150 min=0 max=100>Score: <get score>.
+
+		+ bad bound
+		- <add score=1 max=lots>
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		expect  string
+	}{
+		{"set n to 10", "Set n to 10."},
+		{"div 4", "n is 2.5."},
+		{"add a quarter", "n is 2.75."},
+		{"mult 2", "n is 5.5."},
+		{"take half", "n is 5."},
+		{"div 3", "n is 1.67."},
+		{"set n to 17", "Set n to 17."},
+		{"mod 5", "n is 2."},
+		{"pow 10", "n is 1024."},
+		{"min 100", "n is 100."},
+		{"max 250", "n is 250."},
+		{"max 9", "n is 250."},
+		{"div 0", "[ERR: Can't Divide By Zero]n is 250."},
+		{"add ten", "[ERR: Math can't add non-numeric value ten]n is 250."},
+		{"score 60", "Score: 60."},
+		{"score 60", "Score: 100."},
+		{"lose", "Score: 0."},
+		{"bad bound", "[ERR: Math can't use non-numeric max bound lots]"},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.message, err)
+		} else if reply != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.message, test.expect, reply)
+		}
+	}
+
+	// A negative precision keeps all the digits, and zero rounds to whole
+	// numbers.
+	for precision, expect := range map[int]string{-1: "3.3333333333333335", 0: "3"} {
+		precision := precision
+		bot = New(&Config{MathPrecision: &precision})
+		bot.Stream(`
+			+ third
+			- <set n=10><div n=3><get n>
+		`)
+		bot.SortReplies()
+		if reply, _ := bot.Reply("bob", "third"); reply != expect {
+			t.Errorf("precision %d: expected %q, got %q", precision, expect, reply)
+		}
+	}
+}
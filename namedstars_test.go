@@ -0,0 +1,64 @@
+package rivescript
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamedStars(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ my name is *{name}
+		- <set name=<star name>>Nice to meet you, <star name>.
+
+		+ i am #{age} years old and live in *{first_city}
+		- You're <star age>, from <star first_city> (<star1>, <star2>).
+
+		+ call me _{nick} [please]
+		* <star nick> == bob => Hi, Bob!
+		- OK, <star nick>.
+
+		+ say *{word} [*{ignored}]
+		- You said <star word>, <star ignored>.
+
+		+ repeat *{what}
+		- {@say <star what>} (<star what>)
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		reply   string
+	}{
+		{"my name is alice", "Nice to meet you, alice."},
+		{"i am 30 years old and live in paris", "You're 30, from paris (30, paris)."},
+		{"call me bob", "Hi, Bob!"},
+		{"call me carol please", "OK, carol."},
+
+		// A named wildcard in an optional doesn't capture.
+		{"say hello there", "You said hello, undefined."},
+
+		// The names belong to the trigger whose reply is being processed.
+		{"repeat hi", "You said hi, undefined. (hi)"},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+		} else if reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.message, test.reply, reply)
+		}
+	}
+
+	details, err := bot.ReplyDetailed("alice", "i am 5 years old and live in rome")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := map[string]string{"age": "5", "first_city": "rome"}
+	if !reflect.DeepEqual(details.NamedStars, expect) {
+		t.Errorf("expected named stars %v, got %v", expect, details.NamedStars)
+	}
+	if !reflect.DeepEqual(details.Stars, []string{"5", "rome"}) {
+		t.Errorf("expected the named stars by position, got %v", details.Stars)
+	}
+}
@@ -42,6 +42,11 @@ func (rs *RiveScript) parse(path string, lines []string) error {
 	for k, v := range AST.Begin.Array {
 		rs.array[k] = v
 	}
+	for topic, options := range AST.Begin.Topic {
+		for option, value := range options {
+			rs.setTopicOption(topic, option, value)
+		}
+	}
 
 	// Consume all the parsed triggers.
 	for topic, data := range AST.Topics {
@@ -104,6 +109,7 @@ func (rs *RiveScript) parse(path string, lines []string) error {
 			}
 			if !foundtrigger {
 				trigger := new(astTrigger)
+				trigger.topic = topic
 				trigger.trigger = trig.Trigger
 				trigger.reply = trig.Reply
 				trigger.condition = trig.Condition
@@ -359,6 +359,18 @@ func (self *Parser) Parse(filename string, code []string) (*ast.Root, error) {
 				// Person substitutions
 				self.say("\tSet person substitution %s = %s", name, value)
 				AST.Begin.Person[name] = value
+			case "topic":
+				// Topic options, like `! topic game timeout = 5m`
+				fields := strings.Fields(name)
+				if len(fields) != 2 {
+					self.warn("Topic options look like '! topic <name> <option> = <value>'", filename, lineno)
+					continue
+				}
+				self.say("\tSet topic %s option %s = %s", fields[0], fields[1], value)
+				if _, ok := AST.Begin.Topic[fields[0]]; !ok {
+					AST.Begin.Topic[fields[0]] = map[string]string{}
+				}
+				AST.Begin.Topic[fields[0]][fields[1]] = value
 			default:
 				self.warn("Unknown definition type '%s'", filename, lineno, kind)
 			}
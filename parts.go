@@ -0,0 +1,69 @@
+package rivescript
+
+// Multi-part replies.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ReplyPart is one message of a reply that was split into parts.
+
+A reply is split with the `{split}` and `{pause=ms}` tags. A `{pause}` also
+gives the part that follows it a Delay, as a hint for chat platforms to wait
+(or show a typing indicator) before sending it:
+
+	// A joke in two parts.
+	+ tell me a joke
+	- Why did the chicken cross the road?{pause=1500}To get to the other side!
+*/
+type ReplyPart struct {
+	Text  string        `json:"text"`
+	Delay time.Duration `json:"delay,omitempty"`
+}
+
+/*
+splitReply splits a reply at its `{split}` and `{pause}` tags.
+
+It returns the parts and the reply with the parts joined by spaces, which is
+the text that `Reply()` returns and that is saved to the user's history, so
+that `<reply>` and %Previous see the whole reply. Empty parts are left out,
+but their delays carry on to the next part.
+*/
+func splitReply(reply string) ([]ReplyPart, string) {
+	parts := []ReplyPart{}
+	var delay time.Duration
+	add := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, ReplyPart{Text: text, Delay: delay})
+			delay = 0
+		}
+	}
+
+	matches := reReplyPart.FindAllStringSubmatchIndex(reply, -1)
+	if len(matches) == 0 {
+		if reply != "" {
+			parts = append(parts, ReplyPart{Text: reply})
+		}
+		return parts, reply
+	}
+
+	start := 0
+	for _, match := range matches {
+		add(reply[start:match[0]])
+		if match[2] >= 0 {
+			ms, _ := strconv.Atoi(reply[match[2]:match[3]])
+			delay += time.Duration(ms) * time.Millisecond
+		}
+		start = match[1]
+	}
+	add(reply[start:])
+
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		texts[i] = part.Text
+	}
+	return parts, strings.Join(texts, " ")
+}
@@ -0,0 +1,69 @@
+package rivescript
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplyParts(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ hello
+		- Hi there!{split}How are you?
+
+		+ tell me a joke
+		- Why did the chicken cross the road?{pause=1500}To get to the other side!
+
+		+ nothing special
+		- Just one message.
+
+		+ pauses
+		- {pause=100}One. {split}{pause=200} {split}Two.{split}
+
+		+ why
+		% why did the chicken cross the road to get to the other side
+		- Because <reply> was the punchline.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		reply   string
+		parts   []ReplyPart
+	}{
+		{"hello", "Hi there! How are you?", []ReplyPart{
+			{Text: "Hi there!"},
+			{Text: "How are you?"},
+		}},
+		{"nothing special", "Just one message.", []ReplyPart{
+			{Text: "Just one message."},
+		}},
+		{"pauses", "One. Two.", []ReplyPart{
+			{Text: "One.", Delay: 100 * time.Millisecond},
+			{Text: "Two.", Delay: 200 * time.Millisecond},
+		}},
+		{"tell me a joke", "Why did the chicken cross the road? To get to the other side!", []ReplyPart{
+			{Text: "Why did the chicken cross the road?"},
+			{Text: "To get to the other side!", Delay: 1500 * time.Millisecond},
+		}},
+
+		// The history has the parts joined together.
+		{"why", "Because Why did the chicken cross the road? To get to the other side! was the punchline.", []ReplyPart{
+			{Text: "Because Why did the chicken cross the road? To get to the other side! was the punchline."},
+		}},
+	}
+	for _, test := range tests {
+		details, err := bot.ReplyDetailed("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+			continue
+		}
+		if details.Reply != test.reply {
+			t.Errorf("%s: expected reply %q, got %q", test.message, test.reply, details.Reply)
+		}
+		if !reflect.DeepEqual(details.Parts, test.parts) {
+			t.Errorf("%s: expected parts %+v, got %+v", test.message, test.parts, details.Parts)
+		}
+	}
+}
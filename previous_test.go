@@ -0,0 +1,73 @@
+package rivescript
+
+import "testing"
+
+func TestPreviousHistory(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ order pizza
+		- What size would you like?
+
+		+ (small|medium|large)
+		% what size would you like
+		- <set size=<star>>Which topping?
+
+		+ *
+		% {history=2} what size would you like
+		- One <get size> pizza with <star>, coming up!
+
+		+ yes
+		% {history=2} what size would you like
+		- You said yes two turns after I asked about the size.
+
+		+ yes
+		% which topping
+		- Yes isn't a topping.
+
+		+ *
+		- I don't know what you mean.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		reply   string
+	}{
+		{"order pizza", "What size would you like?"},
+		{"large", "Which topping?"},
+		{"cheese", "One large pizza with cheese, coming up!"},
+
+		// The history moved on.
+		{"cheese", "I don't know what you mean."},
+
+		// A %Previous on the last reply is tried before one further back.
+		{"order pizza", "What size would you like?"},
+		{"small", "Which topping?"},
+		{"yes", "Yes isn't a topping."},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+		} else if reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.message, test.reply, reply)
+		}
+	}
+
+	// Explain looks at the same turn.
+	bot.Reply("bob", "order pizza")
+	bot.Reply("bob", "medium")
+	explain, err := bot.Explain("bob", "pepperoni")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, step := range explain.Steps {
+		if step.Previous == "{history=2} what size would you like" && step.Trigger == "*" {
+			if step.Decision != ExplainSelected {
+				t.Errorf("expected the trigger to be selected, got %s", step.Decision)
+			}
+			return
+		}
+	}
+	t.Errorf("the trigger wasn't explained: %+v", explain.Steps)
+}
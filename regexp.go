@@ -6,10 +6,15 @@ import "regexp"
 var (
 	reWeight        = regexp.MustCompile(`\s*\{weight=(\d+)\}\s*`)
 	reInherits      = regexp.MustCompile(`\{inherits=(\d+)\}`)
+	reSelect        = regexp.MustCompile(`\s*\{select=([^}]*)\}`)
+	reFuzzy         = regexp.MustCompile(`\s*\{fuzzy=(\d+)\}`)
+	reHistory       = regexp.MustCompile(`^\s*\{history=(\d+)\}\s*`)
 	reMeta          = regexp.MustCompile(`[\<>]+`)
 	reSymbols       = regexp.MustCompile(`[.?,!;:@#$%^&*()]+`)
 	reNasties       = regexp.MustCompile(`[^A-Za-z0-9 ]`)
 	reZerowidthstar = regexp.MustCompile(`^\*$`)
+	reNamedStar     = regexp.MustCompile(`([*#_>]|\x05\))\{([A-Za-z][A-Za-z0-9_]*)\}`)
+	reWildcardTag   = regexp.MustCompile(`<([a-z][a-z0-9]*)>`)
 	reOptional      = regexp.MustCompile(`\[(.+?)\]`)
 	reArray         = regexp.MustCompile(`@(.+?)\b`)
 	reReplyArray    = regexp.MustCompile(`\(@([A-Za-z0-9_]+)\)`)
@@ -20,11 +25,22 @@ var (
 	// Self-contained tags like <set> that contain no nested tag.
 	reAnytag = regexp.MustCompile(`<([^<]+?)>`)
 
-	reTopic     = regexp.MustCompile(`\{topic=(.+?)\}`)
-	reRedirect  = regexp.MustCompile(`\{@(.+?)\}`)
-	reCall      = regexp.MustCompile(`<call>(.+?)</call>`)
-	reCondition = regexp.MustCompile(`^(.+?)\s+(==|eq|!=|ne|<>|<|<=|>|>=)\s+(.*?)$`)
-	reSet       = regexp.MustCompile(`<set (.+?)=(.+?)>`)
+	reTopic            = regexp.MustCompile(`\{topic=(.+?)\}`)
+	reTopicStack       = regexp.MustCompile(`\{topic\+=(.+?)\}|\{topic-\}`)
+	reRedirect         = regexp.MustCompile(`\{@(.+?)\}`)
+	reCall             = regexp.MustCompile(`<call>(.+?)</call>`)
+	reConditionDefined = regexp.MustCompile(`^(.+?)\s+is\s+(not\s+)?defined$`)
+	reSet              = regexp.MustCompile(`<set (.+?)=(.+?)>`)
+	reReplyPart        = regexp.MustCompile(`\{(?:split|pause=(\d+))\}`)
+	reRich             = regexp.MustCompile(`\{(button|quickreply|image)=(.+?)\}`)
+
+	// Named wildcards and wildcard classes: their groups in a trigger's
+	// regexp while it's being prepared, and the <star name> tag.
+	reNamedGroup       = regexp.MustCompile(`\(([^()?][^()]*)\)\x02(\d+)\x03`)
+	reNamePlaceholder  = regexp.MustCompile(`\x02\d+\x03`)
+	reClassGroup       = regexp.MustCompile(`\(\x04(\d+)\x05\)`)
+	reClassPlaceholder = regexp.MustCompile(`\x04(\d+)\x05`)
+	reNamedStarTag     = regexp.MustCompile(`<star ([A-Za-z][A-Za-z0-9_]*)>`)
 
 	// Placeholders used during substitutions.
 	rePlaceholder = regexp.MustCompile(`\x00(\d+)\x00`)
@@ -0,0 +1,198 @@
+package rivescript
+
+// Request-scoped state for a single reply.
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aichaos/rivescript-go/sessions"
+)
+
+/*
+Request holds the state of a single call to `Reply()`.
+
+Every reply gets its own Request, so a bot can safely answer many users from
+many goroutines at once. Object macros can get the Request that invoked them
+with `RequestFromContext()` (for subroutines registered with
+`SetContextSubroutine()`) or with `CurrentRequest()`.
+
+The fields of a Request should be treated as read-only, except for Metadata,
+and for Message in HookAfterReply hooks (see `AddHook()`).
+*/
+type Request struct {
+	// Username is the ID of the user who is asking for a reply.
+	Username string
+
+	// Message is the user's message, after it was formatted for matching.
+	// HookBeforeFormat hooks see the message as it was given to Reply().
+	Message string
+
+	// Stars are the wildcard captures of the trigger being processed, and
+	// BotStars are the captures from its %Previous, if any.
+	Stars    []string
+	BotStars []string
+
+	// NamedStars are the captures of the named wildcards of the trigger being
+	// processed, like `*{name}`, by their names.
+	NamedStars map[string]string
+
+	// Metadata is free-form data about the request. It is seeded from the
+	// context with `WithMetadata()` and is shared with every object macro
+	// called while building the reply.
+	Metadata map[string]string
+
+	ctx      context.Context
+	sessions sessions.SessionManager
+	step     uint        // Recursion depth counter
+	chain    []chainLink // The messages being answered, through redirects
+	parent   *Request    // The reply that called this one from an object macro
+
+	// Details of the reply, for ReplyDetailed().
+	details  *ReplyDetails
+	redirect *Redirect // The redirect being followed, if any
+	inline   uint      // How many inline redirects deep we are
+}
+
+// chainLink is a message being answered in a chain of redirects.
+type chainLink struct {
+	message  string
+	topic    string
+	previous bool // Whether %Previous triggers were considered
+
+	// What the user's variables were like when the message was answered:
+	// the count of writes to user variables, and the variables themselves
+	// from userState() if they were read.
+	writes uint64
+	vars   string
+	read   bool
+}
+
+// trackedSessions counts the writes to user variables made during replies, so
+// that redirect cycle detection can tell when nothing has changed without
+// reading the variables.
+type trackedSessions struct {
+	sessions.SessionManager
+	writes *uint64
+}
+
+func (s trackedSessions) Set(username string, vars map[string]string) {
+	atomic.AddUint64(s.writes, 1)
+	s.SessionManager.Set(username, vars)
+}
+
+func (s trackedSessions) Clear(username string) {
+	atomic.AddUint64(s.writes, 1)
+	s.SessionManager.Clear(username)
+}
+
+func (s trackedSessions) ClearAll() {
+	atomic.AddUint64(s.writes, 1)
+	s.SessionManager.ClearAll()
+}
+
+func (s trackedSessions) Thaw(username string, action sessions.ThawAction) error {
+	atomic.AddUint64(s.writes, 1)
+	return s.SessionManager.Thaw(username, action)
+}
+
+// userState sums up a user's variables in a string, so that two points of a
+// reply can tell whether anything changed in between.
+func userState(req *Request) string {
+	data, err := req.sessions.GetAny(req.Username)
+	if err != nil {
+		return ""
+	}
+
+	keys := make([]string, 0, len(data.Variables))
+	for key := range data.Variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var state strings.Builder
+	for _, key := range keys {
+		state.WriteString(key)
+		state.WriteByte(0)
+		state.WriteString(data.Variables[key])
+		state.WriteByte(0)
+	}
+	return state.String()
+}
+
+// Context keys for request-scoped values.
+type requestKey struct{}
+type metadataKey struct{}
+
+/*
+newRequest prepares the state for a new reply.
+
+If the context already carries a Request, the new one is a nested reply made
+from inside an object macro, and it remembers its parent.
+*/
+func (rs *RiveScript) newRequest(ctx context.Context, username, message string) *Request {
+	req := &Request{
+		Username: username,
+		Message:  message,
+		Stars:    []string{},
+		BotStars: []string{},
+		Metadata: map[string]string{},
+
+		NamedStars: map[string]string{},
+		details: &ReplyDetails{
+			Stars:   []string{},
+			Parts:   []ReplyPart{},
+			Started: time.Now(),
+		},
+	}
+
+	if parent, ok := RequestFromContext(ctx); ok {
+		req.parent = parent
+	}
+	if meta, ok := ctx.Value(metadataKey{}).(map[string]string); ok {
+		for k, v := range meta {
+			req.Metadata[k] = v
+		}
+	}
+
+	req.ctx = context.WithValue(ctx, requestKey{}, req)
+	req.sessions = trackedSessions{rs.sessionsFor(req.ctx), rs.varWrites}
+	return req
+}
+
+// Context returns the context of the request. It carries the Request itself,
+// so that it can be recovered with `RequestFromContext()`.
+func (req *Request) Context() context.Context {
+	return req.ctx
+}
+
+// nestedIn tells whether this request is a reply that was requested from
+// inside of another one, at any depth.
+func (req *Request) nestedIn(other *Request) bool {
+	for r := req.parent; r != nil; r = r.parent {
+		if r == other {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestFromContext returns the Request carried by a context, such as the
+// one given to a context-aware object macro.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(*Request)
+	return req, ok
+}
+
+/*
+WithMetadata returns a copy of the context that carries request metadata.
+
+When the context is given to `ReplyContext()`, the metadata is copied into
+the `Metadata` field of the Request, where object macros can read it.
+*/
+func WithMetadata(ctx context.Context, meta map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, meta)
+}
@@ -0,0 +1,69 @@
+package rivescript
+
+// Rich reply payloads: buttons, quick replies and attachments.
+
+import "strings"
+
+/*
+Button is a button or quick reply offered with a reply.
+
+Buttons come from `{button=label|payload}` tags and quick replies from
+`{quickreply=label|payload}` tags. When the user picks one, the chat platform
+should send its Payload back as the user's next message, which is matched to a
+trigger like any other message. If a tag has no payload, the label is used.
+
+	// Offer two quick replies.
+	+ do you want pizza
+	- Do you want pizza?{quickreply=Yes please|yes}{quickreply=No thanks|no}
+*/
+type Button struct {
+	Label   string `json:"label"`
+	Payload string `json:"payload"`
+}
+
+/*
+Attachment is a file offered with a reply, from an `{image=url}` tag.
+
+Type is the kind of attachment, such as "image", and URL is where to find it.
+*/
+type Attachment struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+/*
+extractRich removes the rich reply tags from a reply and adds them to the
+details of the request.
+
+The reply is trimmed of whitespace if any tags were removed from it.
+*/
+func extractRich(reply string, details *ReplyDetails) string {
+	matches := reRich.FindAllStringSubmatch(reply, -1)
+	if len(matches) == 0 {
+		return reply
+	}
+
+	for _, match := range matches {
+		tag, value := match[1], strings.TrimSpace(match[2])
+		switch tag {
+		case "button", "quickreply":
+			button := Button{Label: value, Payload: value}
+			if i := strings.Index(value, "|"); i >= 0 {
+				button.Label = strings.TrimSpace(value[:i])
+				button.Payload = strings.TrimSpace(value[i+1:])
+			}
+			if tag == "button" {
+				details.Buttons = append(details.Buttons, button)
+			} else {
+				details.QuickReplies = append(details.QuickReplies, button)
+			}
+		case "image":
+			details.Attachments = append(details.Attachments, Attachment{
+				Type: tag,
+				URL:  value,
+			})
+		}
+	}
+
+	return strings.TrimSpace(reRich.ReplaceAllString(reply, ""))
+}
@@ -0,0 +1,76 @@
+package rivescript
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRichReplies(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ do you want pizza
+		- Do you want pizza?{quickreply=Yes please|yes pizza}{quickreply=No thanks|no pizza}
+
+		+ yes pizza
+		- Here it is! {image=https://example.com/pizza.png}
+
+		+ no pizza
+		- OK.
+
+		+ menu
+		- What would you like, <id>?
+		^ {button=Pizza|<id> wants pizza}
+		^ {button=Salad}
+	`)
+	bot.SortReplies()
+
+	details, err := bot.ReplyDetailed("alice", "do you want pizza")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if details.Reply != "Do you want pizza?" {
+		t.Errorf("expected the tags to be removed, got %q", details.Reply)
+	}
+	expect := []Button{
+		{Label: "Yes please", Payload: "yes pizza"},
+		{Label: "No thanks", Payload: "no pizza"},
+	}
+	if !reflect.DeepEqual(details.QuickReplies, expect) {
+		t.Errorf("expected quick replies %+v, got %+v", expect, details.QuickReplies)
+	}
+
+	// The payload is matched like any other message.
+	details, err = bot.ReplyDetailed("alice", details.QuickReplies[0].Payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if details.Reply != "Here it is!" || details.Trigger != "yes pizza" {
+		t.Errorf("unexpected reply to the payload: %q from %q", details.Reply, details.Trigger)
+	}
+	attachments := []Attachment{{Type: "image", URL: "https://example.com/pizza.png"}}
+	if !reflect.DeepEqual(details.Attachments, attachments) {
+		t.Errorf("expected attachments %+v, got %+v", attachments, details.Attachments)
+	}
+
+	// Tags in the payloads are processed, and a button with no payload sends
+	// its label.
+	details, err = bot.ReplyDetailed("alice", "menu")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect = []Button{
+		{Label: "Pizza", Payload: "alice wants pizza"},
+		{Label: "Salad", Payload: "Salad"},
+	}
+	if details.Reply != "What would you like, alice?" {
+		t.Errorf("unexpected reply: %q", details.Reply)
+	}
+	if !reflect.DeepEqual(details.Buttons, expect) {
+		t.Errorf("expected buttons %+v, got %+v", expect, details.Buttons)
+	}
+
+	// Reply() gets the text without the tags.
+	if reply, _ := bot.Reply("alice", "yes pizza"); reply != "Here it is!" {
+		t.Errorf("unexpected reply: %q", reply)
+	}
+}
@@ -13,6 +13,7 @@ package rivescript
 */
 
 import (
+	"context"
 	"math/rand"
 	"regexp"
 	"sync"
@@ -35,6 +36,7 @@ type RiveScript struct {
 	Depth              uint // Max depth for recursion
 	UTF8               bool // Support UTF-8 RiveScript code
 	CaseSensitive      bool // Preserve casing on incoming user messages
+	MathPrecision      int  // Decimal places for the results of math tags
 	Quiet              bool // Suppress all warnings from being emitted
 	UnicodePunctuation *regexp.Regexp
 
@@ -53,9 +55,37 @@ type RiveScript struct {
 	inherits    map[string]map[string]bool      // inherited topics
 	objlangs    map[string]string               // object macro languages
 	handlers    map[string]macro.MacroInterface // object language handlers
-	subroutines map[string]Subroutine           // Golang object handlers
-	topics      map[string]*astTopic            // main topic structure
-	sorted      *sortBuffer                     // Sorted data from SortReplies()
+	subroutines map[string]ContextSubroutine    // Golang object handlers
+	conditions  map[string]ConditionOperator    // Custom *Condition operators
+	tags        map[string]TagHandler           // Custom reply tags
+	formats     map[string]FormatFunc           // Custom string formats
+	hooks       map[HookStage][]HookFunc        // Reply pipeline hooks
+	wildcards   map[string]wildcardClass        // Custom wildcard classes
+
+	// Fallback replies.
+	fallbacks      []string            // Global fallback replies
+	topicFallbacks map[string][]string // Fallback replies by topic
+	inlineErrors   InlineRedirectMode  // Handling of failed inline redirects
+
+	// Conditions.
+	lenientConditions bool // Non-numbers in numeric comparisons are false
+
+	// Topic hooks and timeouts.
+	topicConfigs map[string]TopicConfig
+
+	// Reply selection.
+	selection ReplySelection // Default strategy for picking a reply
+
+	// Fuzzy matching.
+	fuzzyDistance int // Default edit distance for fuzzy matches; 0 is off
+
+	// Sentence splitting.
+	splitSentences     bool     // Answer each sentence of a message separately
+	sentenceDelimiters []string // Text that ends a sentence
+	sentenceSeparator  string   // Text that joins the replies to each sentence
+
+	topics map[string]*astTopic // main topic structure
+	sorted *sortBuffer          // Sorted data from SortReplies()
 
 	// The random number god.
 	random     rand.Source
@@ -63,8 +93,9 @@ type RiveScript struct {
 	randomLock sync.Mutex
 
 	// State information.
-	inReplyContext bool
-	currentUser    string
+	stateLock     sync.RWMutex
+	macroRequests []*Request // The requests running object macros, in order.
+	varWrites     *uint64    // How many times user variables were written.
 }
 
 /*
@@ -87,6 +118,19 @@ func New(cfg *Config) *RiveScript {
 	if cfg.Depth == 0 {
 		cfg.Depth = 50
 	}
+	if len(cfg.SentenceDelimiters) == 0 {
+		cfg.SentenceDelimiters = []string{".", "!", "?"}
+	}
+	if cfg.SentenceSeparator == "" {
+		cfg.SentenceSeparator = " "
+	}
+	if cfg.ReplySelection == "" {
+		cfg.ReplySelection = SelectRandom
+	}
+	mathPrecision := 2
+	if cfg.MathPrecision != nil {
+		mathPrecision = *cfg.MathPrecision
+	}
 	if cfg.SessionManager == nil {
 		cfg.SessionManager = memory.New()
 	}
@@ -106,6 +150,7 @@ func New(cfg *Config) *RiveScript {
 		Depth:         cfg.Depth,
 		UTF8:          cfg.UTF8,
 		CaseSensitive: cfg.CaseSensitive,
+		MathPrecision: mathPrecision,
 		sessions:      cfg.SessionManager,
 
 		// Default punctuation that gets removed from messages in UTF-8 mode.
@@ -121,12 +166,34 @@ func New(cfg *Config) *RiveScript {
 		inherits:    map[string]map[string]bool{},
 		objlangs:    map[string]string{},
 		handlers:    map[string]macro.MacroInterface{},
-		subroutines: map[string]Subroutine{},
-		topics:      map[string]*astTopic{},
-		sorted:      new(sortBuffer),
+		subroutines: map[string]ContextSubroutine{},
+		conditions:  map[string]ConditionOperator{},
+		tags:        map[string]TagHandler{},
+		formats:     map[string]FormatFunc{},
+		hooks:       map[HookStage][]HookFunc{},
+		wildcards:   map[string]wildcardClass{},
+
+		fallbacks:      cfg.FallbackReplies,
+		topicFallbacks: cfg.TopicFallbackReplies,
+		inlineErrors:   cfg.InlineRedirectErrors,
+
+		lenientConditions: cfg.LenientConditions,
+
+		topicConfigs: map[string]TopicConfig{},
+		selection:    cfg.ReplySelection,
+
+		fuzzyDistance: cfg.FuzzyDistance,
+
+		splitSentences:     cfg.SplitSentences,
+		sentenceDelimiters: cfg.SentenceDelimiters,
+		sentenceSeparator:  cfg.SentenceSeparator,
+		topics:             map[string]*astTopic{},
+		sorted:             new(sortBuffer),
 
 		random: random,
 		rng:    rand.New(random),
+
+		varWrites: new(uint64),
 	}
 
 	// Helper modules.
@@ -137,6 +204,11 @@ func New(cfg *Config) *RiveScript {
 		OnWarn:  rs.warnSyntax,
 	})
 
+	if !rs.selection.isValid() {
+		rs.warn("Unknown reply selection %s, using random", rs.selection)
+		rs.selection = SelectRandom
+	}
+
 	return rs
 }
 
@@ -152,6 +224,24 @@ const (
 // Subroutine is a function prototype for defining custom object macros in Go.
 type Subroutine func(*RiveScript, []string) string
 
+// ContextSubroutine is like Subroutine, but it also receives the context of
+// the reply that invoked it, as given to `ReplyContext()`.
+type ContextSubroutine func(context.Context, *RiveScript, []string) string
+
+// ConditionOperator is a function prototype for custom *Condition operators.
+// It gets the values on either side of the operator, after their tags were
+// processed, and tells whether the condition is true.
+type ConditionOperator func(left, right string) (bool, error)
+
+// TagHandler is a function prototype for custom tags in replies. It gets the
+// request being answered (with its user, stars and metadata) and the text
+// after the tag's name, and returns the text to replace the tag with.
+type TagHandler func(req *Request, args string) string
+
+// FormatFunc is a function prototype for custom string formats, which are
+// used like `{name}text{/name}` in replies.
+type FormatFunc func(text string) string
+
 // SetUnicodePunctuation allows you to override the text of the unicode
 // punctuation regexp. Provide a string literal that will validate in
 // `regexp.MustCompile()`
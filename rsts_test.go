@@ -2,6 +2,7 @@
 package rivescript
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -170,7 +171,7 @@ func (t *TestCase) expectedError(step TestStep, reply string, err error) error {
 	}
 
 	if expect, ok := goodErrors[step.Reply.(string)]; ok {
-		if err == expect {
+		if errors.Is(err, expect) {
 			return nil
 		}
 	}
@@ -0,0 +1,193 @@
+package rivescript
+
+// Reply selection strategies.
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ReplySelection is a strategy for picking one of the replies of a trigger.
+type ReplySelection string
+
+/*
+The reply selection strategies.
+
+The default strategy is set with `Config.ReplySelection`, and a trigger can
+choose its own with a `{select}` tag:
+
+	// Go through the jokes in order.
+	+ tell me a joke {select=round-robin}
+	- Joke one.
+	- Joke two.
+
+The state of the shuffle, round-robin and no-repeat strategies is kept for
+each user in reserved user variables named like `__select_...__`, so it's
+stored by the SessionManager along with the rest of the user's variables.
+*/
+const (
+	// SelectRandom picks a reply at random every time, using the {weight}
+	// of each reply. This is the default.
+	SelectRandom ReplySelection = "random"
+
+	// SelectShuffle doesn't repeat a reply until all the others were used.
+	// Replies with a higher {weight} tend to come earlier in each round.
+	SelectShuffle ReplySelection = "shuffle"
+
+	// SelectRoundRobin goes through the replies in order, ignoring their
+	// weights.
+	SelectRoundRobin ReplySelection = "round-robin"
+
+	// SelectNoRepeat picks a reply at random, but never the same one twice
+	// in a row.
+	SelectNoRepeat ReplySelection = "no-repeat-last"
+)
+
+// isValid tells whether a selection strategy is known.
+func (s ReplySelection) isValid() bool {
+	switch s {
+	case SelectRandom, SelectShuffle, SelectRoundRobin, SelectNoRepeat:
+		return true
+	}
+	return false
+}
+
+// triggerSelection gets the selection strategy of a trigger, from its
+// {select} tag or the default.
+func (rs *RiveScript) triggerSelection(trigger string) ReplySelection {
+	match := reSelect.FindStringSubmatch(trigger)
+	if len(match) == 0 {
+		return rs.selection
+	}
+
+	selection := ReplySelection(strings.TrimSpace(match[1]))
+	if !selection.isValid() {
+		rs.warn("Unknown reply selection %s in trigger: %s", selection, trigger)
+		return rs.selection
+	}
+	return selection
+}
+
+/*
+selectReply picks one of the replies of a trigger.
+
+The weights of the replies come from their {weight} tags, and the pick is made
+with cumulative weights, so a large weight doesn't cost any memory.
+*/
+func (rs *RiveScript) selectReply(req *Request, trigger *astTrigger) string {
+	replies := trigger.reply
+	if len(replies) == 0 {
+		return ""
+	}
+
+	weights := make([]int, len(replies))
+	for i, rep := range replies {
+		weights[i] = 1
+		if match := reWeight.FindStringSubmatch(rep); len(match) > 0 {
+			weight, _ := strconv.Atoi(match[1])
+			if weight <= 0 {
+				rs.warn("Can't have a weight <= 0!")
+				weight = 1
+			}
+			weights[i] = weight
+		}
+	}
+
+	selection := rs.triggerSelection(trigger.trigger)
+	if selection == SelectRandom || len(replies) == 1 {
+		return replies[rs.weightedPick(weights, nil)]
+	}
+
+	// The strategies with state.
+	key := selectionKey(trigger)
+	state, err := req.sessions.Get(req.Username, key)
+	if err != nil || state == UNDEFINED {
+		state = ""
+	}
+
+	var pick int
+	switch selection {
+	case SelectRoundRobin:
+		next, err := strconv.Atoi(state)
+		if err != nil || next < 0 || next >= len(replies) {
+			next = 0
+		}
+		pick = next
+		state = strconv.Itoa((next + 1) % len(replies))
+	case SelectNoRepeat:
+		last, err := strconv.Atoi(state)
+		pick = rs.weightedPick(weights, func(i int) bool {
+			return err == nil && i == last
+		})
+		state = strconv.Itoa(pick)
+	case SelectShuffle:
+		// The bag holds the replies that haven't been used this round.
+		bag := map[int]bool{}
+		for _, field := range strings.Split(state, ",") {
+			if i, err := strconv.Atoi(field); err == nil && i >= 0 && i < len(replies) {
+				bag[i] = true
+			}
+		}
+		if len(bag) == 0 {
+			for i := range replies {
+				bag[i] = true
+			}
+		}
+
+		pick = rs.weightedPick(weights, func(i int) bool {
+			return !bag[i]
+		})
+		delete(bag, pick)
+
+		remaining := []string{}
+		for i := range replies {
+			if bag[i] {
+				remaining = append(remaining, strconv.Itoa(i))
+			}
+		}
+		state = strings.Join(remaining, ",")
+	}
+
+	req.sessions.Set(req.Username, map[string]string{key: state})
+	return replies[pick]
+}
+
+/*
+weightedPick picks an index at random, using the weights given for each one.
+
+Indexes for which `skip` returns true aren't picked, unless all of them are
+skipped.
+*/
+func (rs *RiveScript) weightedPick(weights []int, skip func(int) bool) int {
+	total := 0
+	for i, weight := range weights {
+		if skip == nil || !skip(i) {
+			total += weight
+		}
+	}
+	if total == 0 {
+		return rs.weightedPick(weights, nil)
+	}
+
+	n := rs.randomInt(total)
+	for i, weight := range weights {
+		if skip != nil && skip(i) {
+			continue
+		}
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+	return len(weights) - 1
+}
+
+// selectionKey is the name of the user variable that holds the selection
+// state for a trigger.
+func selectionKey(trigger *astTrigger) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(trigger.topic + "\x00" + trigger.trigger + "\x00" + trigger.previous))
+	return fmt.Sprintf("__select_%x__", hash.Sum64())
+}
@@ -0,0 +1,120 @@
+package rivescript
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplySelection(t *testing.T) {
+	newBot := func(cfg *Config) *RiveScript {
+		bot := New(cfg)
+		bot.Stream(`
+			+ count
+			- one
+			- two
+			- three
+
+			+ count in order {select=round-robin}
+			- one
+			- two
+			- three
+
+			+ shuffle {select=shuffle}
+			- a{weight=100}
+			- b
+			- c
+			- d
+
+			+ flip {select=no-repeat-last}
+			- heads
+			- tails
+
+			+ weighted
+			- rare
+			- common{weight=1000000}
+		`)
+		bot.SortReplies()
+		return bot
+	}
+
+	bot := newBot(&Config{Seed: 1})
+
+	// Round-robin goes through the replies in order.
+	for i, expect := range []string{"one", "two", "three", "one", "two"} {
+		if reply, _ := bot.Reply("alice", "count in order"); reply != expect {
+			t.Errorf("round-robin #%d: expected %q, got %q", i, expect, reply)
+		}
+	}
+
+	// The state is kept for each user.
+	if reply, _ := bot.Reply("bob", "count in order"); reply != "one" {
+		t.Errorf("expected bob to start over, got %q", reply)
+	}
+
+	// Shuffle doesn't repeat a reply until all were used.
+	for round := 0; round < 5; round++ {
+		seen := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			reply, _ := bot.Reply("alice", "shuffle")
+			if seen[reply] {
+				t.Errorf("shuffle round %d: %q repeated", round, reply)
+			}
+			seen[reply] = true
+		}
+	}
+
+	// No-repeat never gives the same reply twice in a row.
+	last := ""
+	for i := 0; i < 20; i++ {
+		reply, _ := bot.Reply("alice", "flip")
+		if reply == last {
+			t.Errorf("no-repeat-last: %q twice in a row", reply)
+		}
+		last = reply
+	}
+
+	// Large weights work without a bucket of a million replies.
+	for i := 0; i < 10; i++ {
+		if reply, _ := bot.Reply("alice", "weighted"); reply != "common" {
+			t.Errorf("expected the heavily weighted reply, got %q", reply)
+		}
+	}
+
+	// The state lives in reserved user variables, which are kept out of
+	// the public getters.
+	vars, _ := bot.sessions.GetAny("alice")
+	var found string
+	for name := range vars.Variables {
+		if strings.HasPrefix(name, "__select_") {
+			found = name
+		}
+	}
+	if found == "" || !isReservedVar(found) {
+		t.Errorf("expected the selection state in a reserved user variable, got %q", found)
+	}
+	public, _ := bot.GetUservars("alice")
+	if _, ok := public.Variables[found]; ok {
+		t.Errorf("expected GetUservars() to leave out %s", found)
+	}
+	if _, ok := bot.GetAllUservars()["alice"].Variables[found]; ok {
+		t.Errorf("expected GetAllUservars() to leave out %s", found)
+	}
+	if _, err := bot.GetUservar("alice", found); err == nil {
+		t.Errorf("expected an error getting %s", found)
+	}
+	bot.SetUservars("alice", map[string]string{found: "tampered", "name": "Alice"})
+	if value, _ := bot.sessions.Get("alice", found); value == "tampered" {
+		t.Errorf("expected SetUservars() to leave %s alone", found)
+	}
+	if name, _ := bot.GetUservar("alice", "name"); name != "Alice" {
+		t.Errorf("expected SetUservars() to set the other variables, got %q", name)
+	}
+
+	// A global strategy applies to the triggers without a {select} tag.
+	bot = newBot(&Config{ReplySelection: SelectRoundRobin})
+	for i, expect := range []string{"one", "two", "three", "one"} {
+		if reply, _ := bot.Reply("alice", "count"); reply != expect {
+			t.Errorf("global round-robin #%d: expected %q, got %q", i, expect, reply)
+		}
+	}
+}
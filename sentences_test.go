@@ -0,0 +1,92 @@
+package rivescript
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	newBot := func(cfg *Config) *RiveScript {
+		bot := New(cfg)
+		bot.Stream(`
+			+ hi
+			- Hello!
+
+			+ my name is *
+			- <set name=<formal>>Nice to meet you, <get name>.
+
+			+ what is my name
+			- Your name is <get name>.
+
+			+ what did i just say
+			- You said "<input1>".
+		`)
+		bot.SortReplies()
+		return bot
+	}
+
+	// Without splitting, the sentences run together.
+	bot := newBot(nil)
+	if _, err := bot.Reply("alice", "hi. what is my name?"); !errors.Is(err, ErrNoTriggerMatched) {
+		t.Errorf("expected ErrNoTriggerMatched, got %v", err)
+	}
+
+	bot = newBot(&Config{SplitSentences: true})
+	reply, err := bot.Reply("alice", "Hi! My name is alice. What is my name?")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := "Hello! Nice to meet you, Alice. Your name is Alice."; reply != expect {
+		t.Errorf("expected %q, got %q", expect, reply)
+	}
+
+	// Each sentence is added to the history.
+	if reply, _ := bot.Reply("alice", "what did i just say"); reply != `You said "what is my name".` {
+		t.Errorf("unexpected history: %q", reply)
+	}
+
+	// Sentences that match nothing are skipped, unless they all do.
+	if reply, _ := bot.Reply("alice", "blah blah. hi"); reply != "Hello!" {
+		t.Errorf("expected the unmatched sentence to be skipped, got %q", reply)
+	}
+	if _, err := bot.Reply("alice", "blah. blah"); !errors.Is(err, ErrNoTriggerMatched) {
+		t.Errorf("expected ErrNoTriggerMatched, got %v", err)
+	}
+
+	// Fallback replies are skipped like unmatched sentences, and only one is
+	// given if nothing matched.
+	bot = newBot(&Config{SplitSentences: true, FallbackReplies: []string{"I don't understand."}})
+	if reply, _ := bot.Reply("alice", "blah blah. hi"); reply != "Hello!" {
+		t.Errorf("expected the fallback sentence to be skipped, got %q", reply)
+	}
+	details, err := bot.ReplyDetailed("alice", "blah. blah")
+	if err != nil || details.Reply != "I don't understand." || !details.Fallback {
+		t.Errorf("expected a single fallback reply, got %q (%v)", details.Reply, err)
+	}
+
+	// Delimiters inside of a word don't split the message.
+	if got := bot.sentences("i have 3.5 apples. hi"); !reflect.DeepEqual(got, []string{"i have 3.5 apples.", "hi"}) {
+		t.Errorf("unexpected sentences: %q", got)
+	}
+
+	// Custom delimiters and separator, and the details of each sentence.
+	bot = newBot(&Config{
+		SplitSentences:     true,
+		SentenceDelimiters: []string{";"},
+		SentenceSeparator:  "\n",
+	})
+	details, err = bot.ReplyDetailed("bob", "hi; my name is bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := "Hello!\nNice to meet you, Bob."; details.Reply != expect {
+		t.Errorf("expected %q, got %q", expect, details.Reply)
+	}
+	if len(details.Sentences) != 2 {
+		t.Fatalf("expected details for 2 sentences, got %d", len(details.Sentences))
+	}
+	if details.Sentences[0].Trigger != "hi" || details.Trigger != "my name is *" {
+		t.Errorf("unexpected triggers: %q and %q", details.Sentences[0].Trigger, details.Trigger)
+	}
+}
@@ -2,6 +2,8 @@
 // RiveScript.
 package sessions
 
+import "context"
+
 /*
 Interface SessionManager describes a session manager for user variables
 in RiveScript.
@@ -59,6 +61,22 @@ type SessionManager interface {
 	Thaw(username string, ThawAction ThawAction) error
 }
 
+/*
+Interface ContextSessionManager is an optional interface for session managers
+that do I/O, such as talking to a database or cache server.
+
+When RiveScript is asked for a reply with `ReplyContext()`, it calls
+`WithContext()` to get a session manager bound to that request, so that slow
+storage calls are abandoned when the request is cancelled or times out.
+*/
+type ContextSessionManager interface {
+	SessionManager
+
+	// WithContext returns a SessionManager that uses the given context for
+	// all its operations. It shares the underlying storage with the original.
+	WithContext(ctx context.Context) SessionManager
+}
+
 // HistorySize is the number of entries stored in the history.
 const HistorySize int = 9
 
@@ -101,7 +101,7 @@ func (s *MemoryStore) GetAll() map[string]*sessions.UserData {
 	defer s.lock.Unlock()
 
 	// Make safe copies of all our structures.
-	var result map[string]*sessions.UserData
+	result := map[string]*sessions.UserData{}
 	for k, v := range s.users {
 		result[k] = cloneUser(v)
 	}
@@ -129,7 +129,12 @@ func (s *MemoryStore) GetHistory(username string) (*sessions.History, error) {
 	if !ok {
 		return nil, fmt.Errorf(`no data for username "%s"`, username)
 	}
-	return data.History, nil
+
+	// Return a copy, since AddHistory may update it from another goroutine.
+	history := sessions.NewHistory()
+	copy(history.Input, data.History.Input)
+	copy(history.Reply, data.History.Reply)
+	return history, nil
 }
 
 // Clear data for a user.
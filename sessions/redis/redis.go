@@ -4,6 +4,7 @@ package redis
 // NOTE: This source file contains the implementation of a SessionManager.
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -70,6 +71,14 @@ func New(options *Config) *Session {
 	}
 }
 
+// WithContext returns a copy of the session manager whose Redis commands use
+// the given context, so they are abandoned if the context is cancelled.
+func (s *Session) WithContext(ctx context.Context) sessions.SessionManager {
+	clone := *s
+	clone.client = s.client.WithContext(ctx)
+	return &clone
+}
+
 // Init makes sure that a username has a session (creates one if not), and
 // returns the pointer to it in any event.
 func (s *Session) Init(username string) *sessions.UserData {
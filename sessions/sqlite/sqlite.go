@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -61,8 +62,9 @@ WHERE users.id = user_variables.user_id;
 COMMIT;`
 
 type Client struct {
-	lock sync.Mutex
+	lock *sync.Mutex
 	db   *sql.DB
+	ctx  context.Context
 }
 
 // New creates a new Client.
@@ -79,10 +81,21 @@ func New(filename string) (*Client, error) {
 	}
 
 	return &Client{
-		db: db,
+		lock: &sync.Mutex{},
+		db:   db,
+		ctx:  context.Background(),
 	}, nil
 }
 
+// WithContext returns a copy of the Client whose queries use the given
+// context, so they are abandoned if the context is cancelled. The copy shares
+// the database connection with the original.
+func (s *Client) WithContext(ctx context.Context) sessions.SessionManager {
+	clone := *s
+	clone.ctx = ctx
+	return &clone
+}
+
 func (s *Client) Close() error {
 	return s.db.Close()
 }
@@ -96,10 +109,17 @@ func (s *Client) Init(username string) *sessions.UserData {
 			s.lock.Lock()
 			defer s.lock.Unlock()
 
-			tx, _ := s.db.Begin()
-			stmt, _ := tx.Prepare(`INSERT OR IGNORE INTO users (username, last_match) VALUES (?,"");`)
+			tx, err := s.db.BeginTx(s.ctx, nil)
+			if err != nil {
+				return
+			}
+			stmt, err := tx.PrepareContext(s.ctx, `INSERT OR IGNORE INTO users (username, last_match) VALUES (?,"");`)
+			if err != nil {
+				tx.Rollback()
+				return
+			}
 			defer stmt.Close()
-			stmt.Exec(username)
+			stmt.ExecContext(s.ctx, username)
 			tx.Commit()
 		}()
 
@@ -125,11 +145,18 @@ func (s *Client) Set(username string, vars map[string]string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, _ := s.db.Begin()
-	stmt, _ := tx.Prepare(`INSERT OR REPLACE INTO user_variables (user_id, key, value) VALUES ((SELECT id FROM users WHERE username = ?), ?, ?);`)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return
+	}
+	stmt, err := tx.PrepareContext(s.ctx, `INSERT OR REPLACE INTO user_variables (user_id, key, value) VALUES ((SELECT id FROM users WHERE username = ?), ?, ?);`)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
 	defer stmt.Close()
 	for k, v := range vars {
-		stmt.Exec(username, k, v)
+		stmt.ExecContext(s.ctx, username, k, v)
 	}
 	tx.Commit()
 }
@@ -141,10 +168,17 @@ func (s *Client) AddHistory(username, input, reply string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, _ := s.db.Begin()
-	stmt, _ := tx.Prepare(`INSERT INTO history (user_id, input,reply)VALUES((SELECT id FROM users WHERE username = ?),?,?);`)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return
+	}
+	stmt, err := tx.PrepareContext(s.ctx, `INSERT INTO history (user_id, input,reply)VALUES((SELECT id FROM users WHERE username = ?),?,?);`)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
 	defer stmt.Close()
-	stmt.Exec(username, input, reply)
+	stmt.ExecContext(s.ctx, username, input, reply)
 	tx.Commit()
 }
 
@@ -155,17 +189,24 @@ func (s *Client) SetLastMatch(username, trigger string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, _ := s.db.Begin()
-	stmt, _ := tx.Prepare(`UPDATE users SET last_match = ? WHERE username = ?;`)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return
+	}
+	stmt, err := tx.PrepareContext(s.ctx, `UPDATE users SET last_match = ? WHERE username = ?;`)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
 	defer stmt.Close()
-	stmt.Exec(trigger, username)
+	stmt.ExecContext(s.ctx, trigger, username)
 	tx.Commit()
 }
 
 // Get a user variable.
 func (s *Client) Get(username, name string) (string, error) {
 	var value string
-	row := s.db.QueryRow(`SELECT value FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?) AND key = ?;`, username, name)
+	row := s.db.QueryRowContext(s.ctx, `SELECT value FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?) AND key = ?;`, username, name)
 	switch err := row.Scan(&value); err {
 	case sql.ErrNoRows:
 		return "", fmt.Errorf("no %s variable found for user %s", name, username)
@@ -188,7 +229,7 @@ func (s *Client) GetAny(username string) (*sessions.UserData, error) {
 	}
 
 	var variables map[string]string = make(map[string]string)
-	rows, err := s.db.Query(`SELECT key,value FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+	rows, err := s.db.QueryContext(s.ctx, `SELECT key,value FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
 	if err != nil {
 		return nil, err
 	}
@@ -212,7 +253,10 @@ func (s *Client) GetAny(username string) (*sessions.UserData, error) {
 // GetAll gets all data for all users.
 func (s *Client) GetAll() map[string]*sessions.UserData {
 	var users []string = make([]string, 0)
-	rows, _ := s.db.Query(`SELECT username FROM users;`)
+	rows, err := s.db.QueryContext(s.ctx, `SELECT username FROM users;`)
+	if err != nil {
+		return map[string]*sessions.UserData{}
+	}
 	defer rows.Close()
 	var user string
 	for rows.Next() {
@@ -232,7 +276,7 @@ func (s *Client) GetAll() map[string]*sessions.UserData {
 // GetLastMatch returns the last matched trigger for the user,
 func (s *Client) GetLastMatch(username string) (string, error) {
 	var last_match string
-	row := s.db.QueryRow(`SELECT last_match FROM users WHERE username = ?;`, username)
+	row := s.db.QueryRowContext(s.ctx, `SELECT last_match FROM users WHERE username = ?;`, username)
 	switch err := row.Scan(&last_match); err {
 	case sql.ErrNoRows:
 		return "", fmt.Errorf("no last match found for user %s", username)
@@ -255,7 +299,7 @@ func (s *Client) GetHistory(username string) (*sessions.History, error) {
 		data.Reply = append(data.Reply, "undefined")
 	}
 
-	rows, err := s.db.Query("SELECT input,reply FROM history WHERE user_id = (SELECT id FROM users WHERE username = ?) ORDER BY timestamp ASC LIMIT 10;", username)
+	rows, err := s.db.QueryContext(s.ctx, "SELECT input,reply FROM history WHERE user_id = (SELECT id FROM users WHERE username = ?) ORDER BY timestamp ASC LIMIT 10;", username)
 	if err != nil {
 		return data, err
 	}
@@ -282,11 +326,14 @@ func (s *Client) Clear(username string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, _ := s.db.Begin()
-	tx.Exec(`DELETE FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
-	tx.Exec(`DELETE FROM history WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return
+	}
+	tx.ExecContext(s.ctx, `DELETE FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+	tx.ExecContext(s.ctx, `DELETE FROM history WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
 
-	tx.Exec(`DELETE FROM users WHERE username = ?;`, username)
+	tx.ExecContext(s.ctx, `DELETE FROM users WHERE username = ?;`, username)
 	tx.Commit()
 }
 
@@ -295,11 +342,14 @@ func (s *Client) ClearAll() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, _ := s.db.Begin()
-	tx.Exec(`DELETE FROM user_variables;`)
-	tx.Exec(`DELETE FROM history;`)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return
+	}
+	tx.ExecContext(s.ctx, `DELETE FROM user_variables;`)
+	tx.ExecContext(s.ctx, `DELETE FROM history;`)
 
-	s.db.Exec(`DELETE FROM users;`)
+	s.db.ExecContext(s.ctx, `DELETE FROM users;`)
 	tx.Commit()
 }
 
@@ -314,16 +364,16 @@ func (s *Client) Freeze(username string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(s.ctx, nil)
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO frozen_user (user_id, data)VALUES((SELECT id FROM users WHERE username = ?), ?);`)
+	stmt, err := tx.PrepareContext(s.ctx, `INSERT OR REPLACE INTO frozen_user (user_id, data)VALUES((SELECT id FROM users WHERE username = ?), ?);`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(username, string(data))
+	_, err = stmt.ExecContext(s.ctx, username, string(data))
 	if err != nil {
 		return err
 	}
@@ -336,7 +386,7 @@ func (s *Client) Thaw(username string, action sessions.ThawAction) error {
 	user, err := func(u string) (sessions.UserData, error) {
 		var data string
 		var reply sessions.UserData
-		row := s.db.QueryRow(`SELECT data FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+		row := s.db.QueryRowContext(s.ctx, `SELECT data FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
 		switch err := row.Scan(&data); err {
 		case sql.ErrNoRows:
 			return sessions.UserData{}, fmt.Errorf("no rows found")
@@ -360,7 +410,7 @@ func (s *Client) Thaw(username string, action sessions.ThawAction) error {
 			s.lock.Lock()
 			defer s.lock.Unlock()
 
-			_, err = s.db.Exec(`DELETE FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+			_, err = s.db.ExecContext(s.ctx, `DELETE FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
 			if err != nil {
 				return err
 			}
@@ -381,7 +431,7 @@ func (s *Client) Thaw(username string, action sessions.ThawAction) error {
 		s.lock.Lock()
 		defer s.lock.Unlock()
 
-		_, err = s.db.Exec(`DELETE FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
+		_, err = s.db.ExecContext(s.ctx, `DELETE FROM frozen_user WHERE user_id = (SELECT id FROM users WHERE username = ?);`, username)
 		if err != nil {
 			return err
 		}
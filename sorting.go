@@ -4,17 +4,31 @@ package rivescript
 
 import (
 	"errors"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/aichaos/rivescript-go/sessions"
 )
 
 // Sort buffer data, for RiveScript.SortReplies()
 type sortBuffer struct {
 	topics map[string][]sortedTriggerEntry // Topic name -> array of triggers
 	thats  map[string][]sortedTriggerEntry
-	sub    []string // Substitutions
-	person []string // Person substitutions
+	index  map[string]*triggerIndex // Topic name -> matching index
+	sub    []string                 // Substitutions
+	person []string                 // Person substitutions
+
+	// Topic name -> the triggers that messages can be fuzzy matched to.
+	fuzzy map[string][]fuzzyCandidate
+
+	// Compiled regexps.
+	matchers      map[string]*triggerMatcher   // Static pattern -> matcher
+	dynamic       map[string]*triggerMatcher   // Expanded regexp source -> matcher
+	substitutions map[string][4]*regexp.Regexp // Substitution pattern -> regexps
+	matcherLock   sync.RWMutex
 }
 
 // Holds a sorted trigger and the pointer to that trigger's data
@@ -27,6 +41,7 @@ type sortedTriggerEntry struct {
 type sortTrack struct {
 	atomic map[int][]sortedTriggerEntry // Sort by number of whole words
 	option map[int][]sortedTriggerEntry // Sort optionals by number of words
+	class  map[int][]sortedTriggerEntry // Sort wildcard classes by no. of words
 	alpha  map[int][]sortedTriggerEntry // Sort alpha wildcards by no. of words
 	number map[int][]sortedTriggerEntry // Sort numeric wildcards by no. of words
 	wild   map[int][]sortedTriggerEntry // Sort wildcards by no. of words
@@ -71,15 +86,51 @@ func (rs *RiveScript) SortReplies() error {
 
 		// Get all of the %Previous triggers for this topic.
 		thatTriggers := rs.getTopicTriggers(topic, true)
+		for _, trig := range thatTriggers {
+			match := reHistory.FindStringSubmatch(trig.pointer.previous)
+			if len(match) > 0 {
+				if turn, _ := strconv.Atoi(match[1]); turn < 1 || turn > sessions.HistorySize {
+					rs.warn("%%Previous in topic %s looks %s turns back, but only %d are kept: %s",
+						topic, match[1], sessions.HistorySize, trig.pointer.previous)
+				}
+			}
+		}
 
-		// And sort them, too.
-		rs.sorted.thats[topic] = rs.sortTriggerSet(thatTriggers, false)
+		// And sort them, too. The ones that look at the bot's last reply come
+		// first, then those that look further back in the history.
+		thats := rs.sortTriggerSet(thatTriggers, false)
+		sort.SliceStable(thats, func(i, j int) bool {
+			return previousTurn(thats[i].pointer.previous) < previousTurn(thats[j].pointer.previous)
+		})
+		rs.sorted.thats[topic] = thats
 	}
 
 	// Sort the substitution lists.
 	rs.sorted.sub = sortList(rs.sub)
 	rs.sorted.person = sortList(rs.person)
 
+	// Compile the trigger and substitution regexps.
+	rs.compileTriggers()
+
+	// Index the triggers of each topic for matching.
+	rs.sorted.index = map[string]*triggerIndex{}
+	for topic, triggers := range rs.sorted.topics {
+		rs.sorted.index[topic] = rs.newTriggerIndex(triggers)
+	}
+
+	// Find the triggers that can be fuzzy matched, if it's turned on.
+	rs.sorted.fuzzy = map[string][]fuzzyCandidate{}
+	for topic, triggers := range rs.sorted.topics {
+		if rs.fuzzyEnabled(triggers) {
+			rs.sorted.fuzzy[topic] = rs.newFuzzyCandidates(triggers)
+		}
+	}
+
+	// Look for redirects that can only go around in circles.
+	for topic := range rs.sorted.topics {
+		rs.checkRedirectCycles(topic)
+	}
+
 	// Did we sort anything at all?
 	if len(rs.sorted.topics) == 0 && len(rs.sorted.thats) == 0 {
 		return errors.New("SortReplies: ended up with empty trigger lists; did you load any RiveScript code?")
@@ -88,6 +139,63 @@ func (rs *RiveScript) SortReplies() error {
 	return nil
 }
 
+/*
+checkRedirectCycles warns about obvious redirect cycles in a topic.
+
+Starting from each atomic trigger with an `@` redirect, the redirect is
+followed to the trigger that would answer it, as long as the redirect target
+is plain text and the trigger it lands on is static, until it either ends or
+comes back around.
+*/
+func (rs *RiveScript) checkRedirectCycles(topic string) {
+	triggers := rs.sorted.topics[topic]
+	idx := rs.sorted.index[topic]
+	reported := map[int]bool{}
+
+	// next finds the trigger that answers a redirect target.
+	next := func(target string) (int, bool) {
+		for _, i := range idx.candidates(target) {
+			pattern := triggers[i].trigger
+			if isDynamicPattern(pattern) {
+				return 0, false
+			}
+			if _, ok := rs.triggerMatcher(nil, pattern).match(target); ok {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	for start, trig := range triggers {
+		if !isAtomic(trig.trigger) || trig.pointer.redirect == "" || reported[start] {
+			continue
+		}
+
+		seen := map[int]int{} // Trigger position -> index in the path
+		path := []string{}
+		for i, ok := start, true; ok; {
+			if from, loop := seen[i]; loop {
+				if !reported[i] {
+					cycle := append(append([]string{}, path[from:]...), path[from])
+					rs.warn("Redirect cycle in topic %s: %s", topic, strings.Join(cycle, " -> "))
+				}
+				for pos := range seen {
+					reported[pos] = true
+				}
+				break
+			}
+
+			target := strings.ToLower(triggers[i].pointer.redirect)
+			if target == "" || strings.ContainsAny(target, "<{") {
+				break
+			}
+			seen[i] = len(path)
+			path = append(path, triggers[i].pointer.trigger)
+			i, ok = next(target)
+		}
+	}
+}
+
 /*
 sortTriggerSet sorts a group of triggers in an optimal sorting order.
 
@@ -155,7 +263,9 @@ func (rs *RiveScript) sortTriggerSet(triggers []sortedTriggerEntry, excludePrevi
 
 		// Loop through all the triggers.
 		for _, trig := range prior[p] {
-			pattern := trig.trigger
+			pattern := reSelect.ReplaceAllString(trig.trigger, "")
+			pattern = reFuzzy.ReplaceAllString(pattern, "")
+			pattern = reNamedStar.ReplaceAllString(pattern, "$1")
 			rs.say("Looking at trigger: %s", pattern)
 
 			// See if the trigger has an {inherits} tag.
@@ -179,7 +289,15 @@ func (rs *RiveScript) sortTriggerSet(triggers []sortedTriggerEntry, excludePrevi
 			}
 
 			// Start inspecting the trigger's contents.
-			if strings.Contains(pattern, "_") {
+			if rs.hasWildcardClass(pattern) {
+				// Custom wildcard classes included.
+				cnt := wordCount(reWildcardTag.ReplaceAllString(pattern, "*"), false)
+				rs.say("Has wildcard classes with %d words", cnt)
+				if _, ok := track[inherits].class[cnt]; !ok {
+					track[inherits].class[cnt] = []sortedTriggerEntry{}
+				}
+				track[inherits].class[cnt] = append(track[inherits].class[cnt], trig)
+			} else if strings.Contains(pattern, "_") {
 				// Alphabetic wildcard included.
 				cnt := wordCount(pattern, false)
 				rs.say("Has a _ wildcard with %d words", cnt)
@@ -252,6 +370,7 @@ func (rs *RiveScript) sortTriggerSet(triggers []sortedTriggerEntry, excludePrevi
 			// Sort each of the main kinds of triggers by their word counts.
 			running = sortByWords(running, track[ip].atomic)
 			running = sortByWords(running, track[ip].option)
+			running = sortByWords(running, track[ip].class)
 			running = sortByWords(running, track[ip].alpha)
 			running = sortByWords(running, track[ip].number)
 			running = sortByWords(running, track[ip].wild)
@@ -302,11 +421,11 @@ func sortList(dict map[string]string) []string {
 /*
 sortByWords sorts a set of triggers by word count and overall length.
 
-This is a helper function for sorting the `atomic`, `option`, `alpha`, `number`
-and `wild` attributes of the sortTrack and adding them to the running sort
-buffer in that specific order. Since attribute lookup by reflection is expensive
-in Go, this function is given the relevant sort buffer directly, and the current
-running sort buffer to add the results to.
+This is a helper function for sorting the `atomic`, `option`, `class`,
+`alpha`, `number` and `wild` attributes of the sortTrack and adding them to
+the running sort buffer in that specific order. Since attribute lookup by
+reflection is expensive in Go, this function is given the relevant sort buffer
+directly, and the current running sort buffer to add the results to.
 
 The `triggers` parameter is a map between word counts and the triggers that
 fit that number of words.
@@ -382,6 +501,7 @@ func initSortTrack() *sortTrack {
 	return &sortTrack{
 		atomic: map[int][]sortedTriggerEntry{},
 		option: map[int][]sortedTriggerEntry{},
+		class:  map[int][]sortedTriggerEntry{},
 		alpha:  map[int][]sortedTriggerEntry{},
 		number: map[int][]sortedTriggerEntry{},
 		wild:   map[int][]sortedTriggerEntry{},
@@ -4,10 +4,14 @@ package rivescript
 
 import (
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aichaos/rivescript-go/macro"
 	"github.com/aichaos/rivescript-go/sessions"
 	"github.com/mattn/go-shellwords"
 )
@@ -41,17 +45,45 @@ func (rs *RiveScript) formatMessage(msg string, botReply bool) string {
 }
 
 // triggerRegexp prepares a trigger pattern for the regular expression engine.
-func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
+func (rs *RiveScript) triggerRegexp(req *Request, pattern string) string {
 	// If the trigger is simply '*' then the * needs to become (.*?)
 	// to match the blank string too.
 	pattern = reZerowidthstar.ReplaceAllString(pattern, "<zerowidthstar>")
 
+	// Wildcard classes like <email> are set aside as groups until the rest of
+	// the pattern is ready, so that their regexps aren't mistaken for anything.
+	var classes map[string]wildcardClass
+	used := []string{}
+	if strings.Contains(pattern, "<") {
+		classes = rs.wildcardClasses()
+		pattern = reWildcardTag.ReplaceAllStringFunc(pattern, func(tag string) string {
+			name := reWildcardTag.FindStringSubmatch(tag)[1]
+			if _, ok := classes[name]; !ok {
+				return tag
+			}
+			used = append(used, name)
+			return fmt.Sprintf("(\x04%d\x05)", len(used)-1)
+		})
+	}
+
+	// Named wildcards like *{name} are set aside until the wildcards are
+	// turned into groups, so that their names aren't mistaken for anything.
+	names := []string{}
+	pattern = reNamedStar.ReplaceAllStringFunc(pattern, func(star string) string {
+		match := reNamedStar.FindStringSubmatch(star)
+		names = append(names, match[2])
+		return fmt.Sprintf("%s\x02%d\x03", match[1], len(names)-1)
+	})
+
 	// Simple replacements.
 	pattern = strings.Replace(pattern, "*", `(.+?)`, -1)
 	pattern = strings.Replace(pattern, "#", `(\d+?)`, -1)
 	pattern = strings.Replace(pattern, "_", `(\w+?)`, -1)
 	pattern = reWeight.ReplaceAllString(pattern, "")   // Remove {weight} tags
 	pattern = reInherits.ReplaceAllString(pattern, "") // Remove {inherits} tags
+	pattern = reSelect.ReplaceAllString(pattern, "")   // Remove {select} tags
+	pattern = reHistory.ReplaceAllString(pattern, "")  // Remove {history} tags
+	pattern = reFuzzy.ReplaceAllString(pattern, "")    // Remove {fuzzy} tags
 	pattern = strings.Replace(pattern, "<zerowidthstar>", `(.*?)`, -1)
 
 	// UTF-8 mode special characters.
@@ -81,6 +113,7 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 		pipes = strings.Replace(pipes, `(.+?)`, `(?:.+?)`, -1)
 		pipes = strings.Replace(pipes, `(\d+?)`, `(?:\d+?)`, -1)
 		pipes = strings.Replace(pipes, `(\w+?)`, `(?:\w+?)`, -1)
+		pipes = strings.Replace(pipes, "(\x04", "(?:\x04", -1)
 
 		pattern = regReplace(pattern,
 			fmt.Sprintf(`\s*\[%s\]\s*`, quotemeta(match[1])),
@@ -95,6 +128,22 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 	// that matches digits, and similarly [A-Za-z] doesn't work with Unicode.
 	pattern = strings.Replace(pattern, `\w`, `[^\s\d]`, -1)
 
+	// Name the groups of the named wildcards. A named wildcard in an optional
+	// doesn't capture anything, so it loses its name.
+	if len(names) > 0 {
+		pattern = reNamedGroup.ReplaceAllStringFunc(pattern, func(group string) string {
+			match := reNamedGroup.FindStringSubmatch(group)
+			i, _ := strconv.Atoi(match[2])
+			name := names[i]
+			if class := reClassPlaceholder.FindStringSubmatch(match[1]); len(class) > 0 && class[0] == match[1] {
+				n, _ := strconv.Atoi(class[1])
+				name = wildcardGroup(name, used[n], n)
+			}
+			return fmt.Sprintf("(?P<%s>%s)", name, match[1])
+		})
+		pattern = reNamePlaceholder.ReplaceAllString(pattern, "")
+	}
+
 	// Filter in arrays.
 	giveup = 0
 	for strings.Contains(pattern, "@") {
@@ -126,9 +175,11 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 		if len(match) > 0 {
 			name := match[1]
 			rep := ""
+			rs.cLock.Lock()
 			if _, ok := rs.vars[name]; ok {
 				rep = stripNasties(rs.vars[name])
 			}
+			rs.cLock.Unlock()
 			pattern = strings.Replace(pattern, fmt.Sprintf(`<bot %s>`, name), strings.ToLower(rep), -1)
 		}
 	}
@@ -145,7 +196,7 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 		if len(match) > 0 {
 			name := match[1]
 
-			value, err := rs.sessions.Get(username, name)
+			value, err := req.sessions.Get(req.Username, name)
 			if err != nil {
 				value = UNDEFINED
 			}
@@ -167,7 +218,7 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 		for i := 1; i <= sessions.HistorySize; i++ {
 			inputPattern := fmt.Sprintf("<input%d>", i)
 			replyPattern := fmt.Sprintf("<reply%d>", i)
-			history, err := rs.sessions.GetHistory(username)
+			history, err := req.sessions.GetHistory(req.Username)
 			if err == nil {
 				pattern = strings.Replace(pattern, inputPattern, history.Input[i-1], -1)
 				pattern = strings.Replace(pattern, replyPattern, history.Reply[i-1], -1)
@@ -184,6 +235,19 @@ func (rs *RiveScript) triggerRegexp(username string, pattern string) string {
 		pattern = strings.Replace(pattern, `\u0040`, "@", -1)
 	}
 
+	// Fill in the wildcard classes. The groups that weren't named get names
+	// too, so that the matcher can tell which class captured each star.
+	if len(used) > 0 {
+		pattern = reClassGroup.ReplaceAllStringFunc(pattern, func(group string) string {
+			n, _ := strconv.Atoi(reClassGroup.FindStringSubmatch(group)[1])
+			return fmt.Sprintf("(?P<%s>\x04%d\x05)", wildcardGroup("", used[n], n), n)
+		})
+		pattern = reClassPlaceholder.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+			n, _ := strconv.Atoi(reClassPlaceholder.FindStringSubmatch(placeholder)[1])
+			return fmt.Sprintf("(?:%s)", classes[used[n]].pattern)
+		})
+	}
+
 	return pattern
 }
 
@@ -192,14 +256,24 @@ processTags processes tags in a reply element.
 
 Params:
 
-	username: The name of the user.
+	req: The state of the request.
 	message: The user's message.
 	reply: The reply element to process tags on.
 	st: Array of matched stars in the trigger.
 	bst: Array of matched bot stars in a %Previous.
-	step: Recursion depth counter.
+
+An error is returned only if the request's context was cancelled while the
+tags were being processed.
 */
-func (rs *RiveScript) processTags(username string, message string, reply string, st []string, bst []string, step uint) string {
+func (rs *RiveScript) processTags(req *Request, message string, reply string, st []string, bst []string) (string, error) {
+	ctx := req.ctx
+	username := req.Username
+	store := req.sessions
+
+	// Expose the stars of the trigger being processed to object macros.
+	req.Stars, req.BotStars = st, bst
+	named := req.NamedStars
+
 	// Prepare the stars and botstars.
 	stars := []string{""}
 	stars = append(stars, st...)
@@ -245,6 +319,10 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 	reply = strings.Replace(reply, "<sentence>", "{sentence}<star>{/sentence}", -1)
 	reply = strings.Replace(reply, "<uppercase>", "{uppercase}<star>{/uppercase}", -1)
 	reply = strings.Replace(reply, "<lowercase>", "{lowercase}<star>{/lowercase}", -1)
+	customFormats, customNames := rs.customFormats()
+	for _, format := range customNames {
+		reply = strings.Replace(reply, fmt.Sprintf("<%s>", format), fmt.Sprintf("{%s}<star>{/%s}", format, format), -1)
+	}
 
 	// Weight and star tags.
 	reply = reWeight.ReplaceAllString(reply, "") // Remove {weight} tags.
@@ -256,11 +334,20 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 	for i := 1; i < len(botstars); i++ {
 		reply = strings.Replace(reply, fmt.Sprintf("<botstar%d>", i), botstars[i], -1)
 	}
+	if strings.Contains(reply, "<star ") {
+		reply = reNamedStarTag.ReplaceAllStringFunc(reply, func(tag string) string {
+			name := reNamedStarTag.FindStringSubmatch(tag)[1]
+			if value, ok := named[name]; ok {
+				return value
+			}
+			return UNDEFINED
+		})
+	}
 
 	// <input> and <reply>
 	reply = strings.Replace(reply, "<input>", "<input1>", -1)
 	reply = strings.Replace(reply, "<reply>", "<reply1>", -1)
-	history, err := rs.sessions.GetHistory(username)
+	history, err := store.GetHistory(username)
 	if err == nil {
 		for i := 1; i <= sessions.HistorySize; i++ {
 			reply = strings.Replace(reply, fmt.Sprintf("<input%d>", i), history.Input[i-1], -1)
@@ -268,8 +355,11 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 		}
 	}
 
-	// <id> and escape codes.
+	// <id>, <topicstack> and escape codes.
 	reply = strings.Replace(reply, "<id>", username, -1)
+	if strings.Contains(reply, "<topicstack>") {
+		reply = strings.Replace(reply, "<topicstack>", rs.topicStackText(req), -1)
+	}
 	reply = strings.Replace(reply, `\s`, " ", -1)
 	reply = strings.Replace(reply, `\n`, "\n", -1)
 	reply = strings.Replace(reply, `\#`, "#", -1)
@@ -302,9 +392,10 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 	}
 
 	// Person substitution and string formatting.
-	formats := []string{"person", "formal", "sentence", "uppercase", "lowercase"}
+	formats := append(append([]string{}, builtinFormats...), customNames...)
 	for _, format := range formats {
-		formatRegexp := regexp.MustCompile(fmt.Sprintf(`\{%s\}(.+?)\{/%s\}`, format, format))
+		quoted := regexp.QuoteMeta(format)
+		formatRegexp := regexp.MustCompile(fmt.Sprintf(`\{%s\}(.+?)\{/%s\}`, quoted, quoted))
 		match = formatRegexp.FindStringSubmatch(reply)
 		giveup = 0
 		for len(match) > 0 {
@@ -318,6 +409,8 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 			var replace string
 			if format == "person" {
 				replace = rs.substitute(content, rs.person, rs.sorted.person)
+			} else if fn, ok := customFormats[format]; ok {
+				replace = fn(content)
 			} else {
 				replace = stringFormat(format, content)
 			}
@@ -358,6 +451,7 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 				target = rs.global
 			}
 
+			rs.cLock.Lock()
 			if strings.Contains(data, "=") {
 				// Assigning the value.
 				parts := strings.Split(data, "=")
@@ -371,71 +465,30 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 					insert = UNDEFINED
 				}
 			}
+			rs.cLock.Unlock()
 		} else if tag == "set" {
 			// <set> user vars
 			parts := strings.Split(data, "=")
 			if len(parts) > 1 {
 				rs.say("Set uservar %s = %s", parts[0], parts[1])
-				rs.sessions.Set(username, map[string]string{parts[0]: parts[1]})
+				rs.setUservar(req, parts[0], parts[1])
 			} else {
 				rs.warn("Malformed <set> tag: %s", match)
 			}
-		} else if tag == "add" || tag == "sub" || tag == "mult" || tag == "div" {
+		} else if isMathTag(tag) {
 			// Math operator tags.
-			parts := strings.Split(data, "=")
-			name := parts[0]
-			strValue := parts[1]
-
-			// Initialize the variable?
-			var origStr string
-			origStr, err = rs.sessions.Get(username, name)
-			if err != nil {
-				rs.sessions.Set(username, map[string]string{name: "0"})
-				origStr = "0"
-			}
-
-			// Sanity check.
-			var value int
-			value, err = strconv.Atoi(strValue)
-			abort := false
-			if err != nil {
-				insert = fmt.Sprintf("[ERR: Math can't %s non-numeric value %s]", tag, strValue)
-				abort = true
-			}
-			var orig int
-			orig, err = strconv.Atoi(origStr)
-			if err != nil {
-				insert = fmt.Sprintf("[ERR: Math can't %s non-numeric user variable %s]", tag, name)
-				abort = true
-			}
-
-			if !abort {
-				result := orig
-				if tag == "add" {
-					result += value
-				} else if tag == "sub" {
-					result -= value
-				} else if tag == "mult" {
-					result *= value
-				} else if tag == "div" {
-					if value == 0 {
-						insert = "[ERR: Can't Divide By Zero]"
-					} else {
-						result /= value
-					}
-				}
-
-				if len(insert) == 0 {
-					// Save it to their account.
-					rs.sessions.Set(username, map[string]string{name: strconv.Itoa(result)})
-				}
-			}
+			insert = rs.mathTag(req, tag, data)
 		} else if tag == "get" {
 			// <get> user vars
-			insert, err = rs.sessions.Get(username, data)
+			insert, err = store.Get(username, data)
 			if err != nil {
 				insert = UNDEFINED
 			}
+		} else if handler := rs.tagHandler(tag); handler != nil {
+			// Custom tags. Mangle any tags in the result so that they're
+			// left alone.
+			insert = handler(req, data)
+			insert = strings.NewReplacer("<", "\x00", ">", "\x01").Replace(insert)
 		} else {
 			// Unrecognized tag; preserve it.
 			insert = fmt.Sprintf("\x00%s\x01", match)
@@ -459,10 +512,16 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 		}
 
 		name := match[1]
-		rs.sessions.Set(username, map[string]string{"topic": name})
+		rs.setTopic(req, name)
 		reply = strings.Replace(reply, fmt.Sprintf("{topic=%s}", name), "", -1)
 		match = reTopic.FindStringSubmatch(reply)
 	}
+	reply = rs.processTopicStack(req, reply)
+
+	// Stop here if the request has been cancelled.
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
 	// Inline redirector.
 	match = reRedirect.FindStringSubmatch(reply)
@@ -476,14 +535,34 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 
 		target := match[1]
 		rs.say("Inline redirection to: %s", target)
-		subreply, err := rs.getReply(username, strings.TrimSpace(target), false, step+1)
+		subreply, err := rs.redirect(req, strings.TrimSpace(target), false, true)
 		if err != nil {
-			subreply = err.Error()
+			// A cancelled request can't be papered over with error text.
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+
+			switch rs.inlineErrors {
+			case InlineRedirectEmpty:
+				subreply = ""
+			case InlineRedirectFallback:
+				subreply, err = rs.fallbackReply(req, strings.TrimSpace(target), err)
+				if err != nil {
+					subreply = ""
+				}
+			case InlineRedirectError:
+				return "", err
+			default:
+				subreply = err.Error()
+			}
 		}
 		reply = strings.Replace(reply, fmt.Sprintf("{@%s}", target), subreply, -1)
 		match = reRedirect.FindStringSubmatch(reply)
 	}
 
+	// The inline redirects may have processed tags for other triggers.
+	req.Stars, req.BotStars, req.NamedStars = st, bst, named
+
 	// Object caller.
 	reply = strings.Replace(reply, "{__call__}", "<call>", -1)
 	reply = strings.Replace(reply, "{/__call__}", "</call>", -1)
@@ -509,22 +588,92 @@ func (rs *RiveScript) processTags(username string, message string, reply string,
 		}
 
 		// Do we know this object?
-		var output string
-		if _, ok := rs.subroutines[obj]; ok {
-			// It exists as a native Go macro.
-			output = rs.subroutines[obj](rs, args)
-		} else if _, ok := rs.objlangs[obj]; ok {
-			lang := rs.objlangs[obj]
-			output = rs.handlers[lang].Call(obj, args)
-		} else {
-			output = "[ERR: Object Not Found]"
+		output, err := rs.callMacro(req, obj, args)
+		if err != nil {
+			return "", err
 		}
 
 		reply = strings.Replace(reply, fmt.Sprintf("<call>%s</call>", match[1]), output, -1)
 		match = reCall.FindStringSubmatch(reply)
 	}
 
-	return reply
+	return reply, nil
+}
+
+/*
+callMacro invokes an object macro by name.
+
+Go subroutines are preferred over objects defined in a foreign language. If
+the language handler supports contexts, the request's context is passed
+along so that it can abandon a slow macro call. An error is returned only if
+the context was cancelled; other failures become the macro's output, like
+"[ERR: Object Not Found]".
+
+Object macros for different requests may run at the same time, so language
+handlers have to be safe for concurrent use. While a macro runs, its request
+is registered for `CurrentUser()` and `CurrentRequest()`.
+*/
+func (rs *RiveScript) callMacro(req *Request, obj string, args []string) (string, error) {
+	ctx := req.ctx
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// Publish the request for CurrentUser() and CurrentRequest().
+	rs.stateLock.Lock()
+	rs.macroRequests = append(rs.macroRequests, req)
+	rs.stateLock.Unlock()
+	defer func() {
+		rs.stateLock.Lock()
+		for i := len(rs.macroRequests) - 1; i >= 0; i-- {
+			if rs.macroRequests[i] == req {
+				rs.macroRequests = append(rs.macroRequests[:i], rs.macroRequests[i+1:]...)
+				break
+			}
+		}
+		rs.stateLock.Unlock()
+	}()
+
+	rs.cLock.Lock()
+	subroutine, isGo := rs.subroutines[obj]
+	lang, isForeign := rs.objlangs[obj]
+	handler := rs.handlers[lang]
+	rs.cLock.Unlock()
+
+	// Record the call in the details of the reply.
+	call := MacroCall{
+		Name: obj,
+		Args: args,
+	}
+	started := time.Now()
+	defer func() {
+		call.Duration = time.Since(started)
+		req.details.Macros = append(req.details.Macros, call)
+	}()
+
+	if isGo {
+		// It exists as a native Go macro.
+		call.Language = "go"
+		return subroutine(ctx, rs, args), nil
+	} else if isForeign && handler != nil {
+		call.Language = lang
+		if ch, ok := handler.(macro.ContextMacroInterface); ok {
+			output, err := ch.CallContext(ctx, obj, args)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return "", ctxErr
+				}
+				call.Err = &MacroError{Object: obj, Language: lang, Err: err}
+				rs.warn("Error in %s", call.Err)
+			}
+			return output, nil
+		}
+		return handler.Call(obj, args), nil
+	}
+
+	call.Language = lang
+	call.Err = &MacroError{Object: obj, Language: lang, Err: ErrObjectNotFound}
+	return "[ERR: Object Not Found]", nil
 }
 
 // substitute applies a substitution to an input message.
@@ -542,7 +691,7 @@ func (rs *RiveScript) substitute(message string, subs map[string]string, sorted
 
 	for _, pattern := range sorted {
 		result := subs[pattern]
-		qm := quotemeta(pattern)
+		re := rs.substitutionRegexps(pattern)
 		// fmt.Printf("Pattern: %s; Result: %s\n", pattern, result)
 
 		// Make a placeholder.
@@ -551,10 +700,10 @@ func (rs *RiveScript) substitute(message string, subs map[string]string, sorted
 		pi++
 
 		// Run substitutions.
-		message = regReplace(message, fmt.Sprintf(`^%s$`, qm), placeholder)
-		message = regReplace(message, fmt.Sprintf(`^%s(\W+)`, qm), fmt.Sprintf("%s$1", placeholder))
-		message = regReplace(message, fmt.Sprintf(`(\W+)%s(\W+)`, qm), fmt.Sprintf("$1%s$2", placeholder))
-		message = regReplace(message, fmt.Sprintf(`(\W+)%s$`, qm), fmt.Sprintf("$1%s", placeholder))
+		message = regReplaceCompiled(message, re[0], placeholder)
+		message = regReplaceCompiled(message, re[1], fmt.Sprintf("%s$1", placeholder))
+		message = regReplaceCompiled(message, re[2], fmt.Sprintf("$1%s$2", placeholder))
+		message = regReplaceCompiled(message, re[3], fmt.Sprintf("$1%s", placeholder))
 	}
 
 	// Convert the placeholders back in.
@@ -578,3 +727,165 @@ func (rs *RiveScript) substitute(message string, subs map[string]string, sorted
 
 	return message
 }
+
+// tagHandler gets the handler for a custom tag, or nil if there isn't one.
+func (rs *RiveScript) tagHandler(name string) TagHandler {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+	return rs.tags[name]
+}
+
+// The built-in string formats, in the order they're applied.
+var builtinFormats = []string{"person", "formal", "sentence", "uppercase", "lowercase"}
+
+// customFormats gets the custom string formats and their names in sorted
+// order.
+func (rs *RiveScript) customFormats() (map[string]FormatFunc, []string) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	formats := map[string]FormatFunc{}
+	names := []string{}
+	for name, fn := range rs.formats {
+		formats[name] = fn
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return formats, names
+}
+
+// The names of custom string formats, and the tags that can't be used as
+// names because replies use them for something else.
+var (
+	reFormatName   = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	reservedFormat = regexp.MustCompile(`^(?:` +
+		`person|formal|sentence|uppercase|lowercase|` + // Built-in formats
+		`star\d*|botstar\d*|input\d*|reply\d*|id|topicstack|` + // <star>, <id>...
+		`bot|env|set|get|add|sub|mult|div|mod|pow|min|max|call|` + // <get name>...
+		`random|topic|weight|split|pause|button|quickreply|image|ok` + // {random}...
+		`)$`)
+)
+
+// checkFormatName makes sure a name can be used for a custom string format.
+func checkFormatName(name string) error {
+	if !reFormatName.MatchString(name) {
+		return fmt.Errorf("invalid format name %q: it must be lowercase letters and numbers", name)
+	}
+	if reservedFormat.MatchString(name) {
+		return fmt.Errorf("invalid format name %q: <%s> or {%s} is already used in replies", name, name, name)
+	}
+	return nil
+}
+
+// isMathTag tells whether a tag is one of the math operator tags.
+func isMathTag(tag string) bool {
+	switch tag {
+	case "add", "sub", "mult", "div", "mod", "pow", "min", "max":
+		return true
+	}
+	return false
+}
+
+/*
+mathTag runs a math operator tag on a user variable, like `<add score=5>`.
+
+The result may be clamped with `min` and `max` bounds, as in
+`<add score=5 max=100>`. The new value is saved to the user variable and
+nothing is returned, unless something went wrong, in which case the error is
+returned in the text of the reply.
+*/
+func (rs *RiveScript) mathTag(req *Request, tag, data string) string {
+	fields := strings.Fields(data)
+	if len(fields) == 0 || !strings.Contains(fields[0], "=") {
+		rs.warn("Malformed <%s> tag: %s", tag, data)
+		return ""
+	}
+	parts := strings.SplitN(fields[0], "=", 2)
+	name, strValue := parts[0], parts[1]
+
+	// Initialize the variable?
+	origStr, err := req.sessions.Get(req.Username, name)
+	if err != nil {
+		req.sessions.Set(req.Username, map[string]string{name: "0"})
+		origStr = "0"
+	}
+
+	// Sanity check.
+	value, err := strconv.ParseFloat(strValue, 64)
+	if err != nil {
+		return fmt.Sprintf("[ERR: Math can't %s non-numeric value %s]", tag, strValue)
+	}
+	orig, err := strconv.ParseFloat(origStr, 64)
+	if err != nil {
+		return fmt.Sprintf("[ERR: Math can't %s non-numeric user variable %s]", tag, name)
+	}
+
+	var result float64
+	switch tag {
+	case "add":
+		result = orig + value
+	case "sub":
+		result = orig - value
+	case "mult":
+		result = orig * value
+	case "div":
+		if value == 0 {
+			return "[ERR: Can't Divide By Zero]"
+		}
+		result = orig / value
+	case "mod":
+		if value == 0 {
+			return "[ERR: Can't Divide By Zero]"
+		}
+		result = math.Mod(orig, value)
+	case "pow":
+		result = math.Pow(orig, value)
+	case "min":
+		result = math.Min(orig, value)
+	case "max":
+		result = math.Max(orig, value)
+	}
+
+	// Clamp the result to its bounds.
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || (parts[0] != "min" && parts[0] != "max") {
+			rs.warn("Unknown parameter in <%s> tag: %s", tag, field)
+			continue
+		}
+
+		bound, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return fmt.Sprintf("[ERR: Math can't use non-numeric %s bound %s]", parts[0], parts[1])
+		}
+		if parts[0] == "min" {
+			result = math.Max(result, bound)
+		} else {
+			result = math.Min(result, bound)
+		}
+	}
+
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return fmt.Sprintf("[ERR: Math result of %s for %s is not a number]", tag, name)
+	}
+
+	// Save it to their account.
+	req.sessions.Set(req.Username, map[string]string{name: rs.formatNumber(result)})
+	return ""
+}
+
+// formatNumber formats the result of a math tag to the bot's precision.
+func (rs *RiveScript) formatNumber(value float64) string {
+	if rs.MathPrecision < 0 {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	result := strconv.FormatFloat(value, 'f', rs.MathPrecision, 64)
+	if strings.Contains(result, ".") {
+		result = strings.TrimRight(strings.TrimRight(result, "0"), ".")
+	}
+	if result == "-0" {
+		result = "0"
+	}
+	return result
+}
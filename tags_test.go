@@ -0,0 +1,118 @@
+package rivescript
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTag(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ my name is *
+		- <set name=<formal>>Nice to meet you, <shout <get name>>!
+
+		+ who am i
+		- You are <whoami>.
+
+		+ what is *
+		- <star> is <weird>.
+
+		+ unknown
+		- <unknown tag> stays.
+	`)
+	bot.SortReplies()
+
+	bot.RegisterTag("shout", func(req *Request, args string) string {
+		return strings.ToUpper(args)
+	})
+	bot.RegisterTag("WhoAmI", func(req *Request, args string) string {
+		return req.Username + " from " + req.Metadata["channel"]
+	})
+	bot.RegisterTag("weird", func(req *Request, args string) string {
+		return "<" + req.Stars[0] + ">"
+	})
+
+	tests := []struct {
+		message string
+		expect  string
+	}{
+		{"my name is bob", "Nice to meet you, BOB!"},
+		{"who am i", "You are alice from web."},
+		{"what is this", "this is <this>."},
+		{"unknown", "<unknown tag> stays."},
+	}
+	ctx := WithMetadata(context.Background(), map[string]string{"channel": "web"})
+	for _, test := range tests {
+		reply, err := bot.ReplyContext(ctx, "alice", test.message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.message, err)
+		} else if reply != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.message, test.expect, reply)
+		}
+	}
+
+	bot.UnregisterTag("shout")
+	if reply, _ := bot.Reply("alice", "my name is bob"); reply != "Nice to meet you, <shout Bob>!" {
+		t.Errorf("expected the unregistered tag to be kept, got %q", reply)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ slug *
+		- {slug}<star>{/slug}
+
+		+ reverse *
+		- <reverse>
+
+		+ shout *
+		- {reverse}{uppercase}<star>{/uppercase}{/reverse}!
+
+		+ builtin *
+		- <id> said <star>, {uppercase}<star>{/uppercase}
+	`)
+	bot.SortReplies()
+
+	// Bad names and the names of built-in tags.
+	for _, name := range []string{"Slug", "my_format", "", "star", "star2", "id", "input",
+		"reply1", "bot", "get", "formal", "uppercase", "random"} {
+		if err := bot.RegisterFormat(name, strings.ToUpper); err == nil {
+			t.Errorf("expected an error for the format name %q", name)
+		}
+	}
+
+	if err := bot.RegisterFormat("slug", func(text string) string {
+		return strings.Join(strings.Fields(text), "-")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.RegisterFormat("reverse", func(text string) string {
+		runes := []rune(text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		message string
+		expect  string
+	}{
+		{"slug hello big world", "hello-big-world"},
+		{"reverse abc def", "fed cba"},
+		{"shout hey", "YEH!"},
+		{"builtin this", "alice said this, THIS"},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.message, err)
+		} else if reply != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.message, test.expect, reply)
+		}
+	}
+}
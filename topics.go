@@ -0,0 +1,183 @@
+package rivescript
+
+// Topic configuration: lifecycle hooks and timeouts.
+
+import (
+	"strconv"
+	"time"
+)
+
+// TopicHandler is a function prototype for topic lifecycle hooks. It gets the
+// request that moved the user, and the topics they moved from and to.
+type TopicHandler func(req *Request, from, to string)
+
+/*
+TopicConfig holds the options for a topic.
+
+The timeout options can also be set in RiveScript code, with `! topic` lines:
+
+	! topic game timeout = 5m
+	! topic game timeout_topic = lobby
+
+A timeout is a Go duration like "90s" or "5m", or a number of seconds.
+*/
+type TopicConfig struct {
+	// OnEnter is called when a user moves into the topic, and OnLeave when
+	// they move out of it, whether it's by a `{topic}` tag, the topic stack
+	// or a timeout. Changes made with `SetUservar()` don't call them.
+	OnEnter TopicHandler
+	OnLeave TopicHandler
+
+	// Timeout is how long a user may stay idle in the topic. When they send a
+	// message after a longer time, they're moved to the TimeoutTopic (by
+	// default "random") before their message is answered, and their topic
+	// stack is cleared. Zero means no timeout, in which case the timeout of a
+	// topic that this one includes or inherits is used, if any.
+	//
+	// The time of each user's last message is kept in the reserved
+	// `__lastmessage__` user variable while any topic has a timeout; it
+	// isn't returned by GetUservars() and can't be set with SetUservar().
+	Timeout      time.Duration
+	TimeoutTopic string
+}
+
+// lastMessageVar is the user variable that holds the time of a user's last
+// message, for topic timeouts. It's a reserved variable, so GetUservars()
+// leaves it out and SetUservar() can't change it.
+const lastMessageVar = "__lastmessage__"
+
+/*
+SetTopicConfig sets the options for a topic, replacing any that were set
+before, including the ones from `! topic` lines in RiveScript code.
+
+	cfg := bot.GetTopicConfig("game")
+	cfg.OnLeave = func(req *rivescript.Request, from, to string) {
+		log.Printf("%s stopped playing", req.Username)
+	}
+	bot.SetTopicConfig("game", cfg)
+
+Parameters
+
+	name: The name of the topic.
+	cfg: The options for the topic.
+*/
+func (rs *RiveScript) SetTopicConfig(name string, cfg TopicConfig) {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	rs.topicConfigs[name] = cfg
+}
+
+/*
+GetTopicConfig gets the options for a topic. A topic with no options gets the
+zero value.
+
+Parameters
+
+	name: The name of the topic.
+*/
+func (rs *RiveScript) GetTopicConfig(name string) TopicConfig {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	return rs.topicConfigs[name]
+}
+
+// setTopicOption sets a topic option from a `! topic` line.
+func (rs *RiveScript) setTopicOption(topic, option, value string) {
+	cfg := rs.GetTopicConfig(topic)
+	switch option {
+	case "timeout":
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			rs.warn("Invalid timeout for topic %s: %s", topic, value)
+			return
+		}
+		cfg.Timeout = timeout
+	case "timeout_topic":
+		cfg.TimeoutTopic = value
+	default:
+		rs.warn("Unknown option %s for topic %s", option, topic)
+		return
+	}
+	rs.SetTopicConfig(topic, cfg)
+}
+
+// parseTimeout parses a topic timeout, which is a Go duration or a number of
+// seconds.
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// hasTopicTimeouts tells whether any topic has a timeout.
+func (rs *RiveScript) hasTopicTimeouts() bool {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	for _, cfg := range rs.topicConfigs {
+		if cfg.Timeout > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+checkTopicTimeout moves a user out of their topic if they were idle in it for
+longer than its timeout, and then records the time of their new message.
+*/
+func (rs *RiveScript) checkTopicTimeout(req *Request) {
+	if !rs.hasTopicTimeouts() {
+		return
+	}
+	now := time.Now()
+
+	topic := rs.currentTopic(req)
+	cfg := rs.topicTimeout(topic)
+	if cfg.Timeout > 0 {
+		value, err := req.sessions.Get(req.Username, lastMessageVar)
+		if err == nil {
+			if last, err := time.Parse(time.RFC3339Nano, value); err == nil && now.Sub(last) > cfg.Timeout {
+				to := cfg.TimeoutTopic
+				if to == "" {
+					to = "random"
+				}
+				rs.say("User %s timed out of topic %s; moving them to %s", req.Username, topic, to)
+				rs.setTopicStack(req, []string{})
+				rs.setTopic(req, to)
+			}
+		}
+	}
+
+	req.sessions.Set(req.Username, map[string]string{
+		lastMessageVar: now.Format(time.RFC3339Nano),
+	})
+}
+
+// topicTimeout gets the timeout options for a topic. A topic with no timeout
+// of its own uses the one of a topic that it includes or inherits, if any.
+func (rs *RiveScript) topicTimeout(topic string) TopicConfig {
+	topics := []string{topic}
+	if len(rs.includes[topic]) > 0 || len(rs.inherits[topic]) > 0 {
+		topics = rs.getTopicTree(topic, 0)
+	}
+	for _, name := range topics {
+		if cfg := rs.GetTopicConfig(name); cfg.Timeout > 0 {
+			return cfg
+		}
+	}
+	return TopicConfig{}
+}
+
+// topicChanged calls the lifecycle hooks for a user moving between topics.
+func (rs *RiveScript) topicChanged(req *Request, from, to string) {
+	if leave := rs.GetTopicConfig(from).OnLeave; leave != nil {
+		leave(req, from, to)
+	}
+	if enter := rs.GetTopicConfig(to).OnEnter; enter != nil {
+		enter(req, from, to)
+	}
+}
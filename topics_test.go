@@ -0,0 +1,133 @@
+package rivescript
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTopicHooks(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ play
+		- {topic=game}Let's play.
+
+		+ resume
+		- <set topic=game>Welcome back.
+
+		> topic game
+			+ quit
+			- {topic=random}Bye.
+
+			+ pause
+			- <set topic=random>Paused.
+
+			+ help
+			- {topic+=help}What do you need?
+		< topic
+
+		> topic help
+			+ thanks
+			- {topic-}Back to the game.
+		< topic
+	`)
+	bot.SortReplies()
+
+	var events []string
+	bot.SetTopicConfig("game", TopicConfig{
+		OnEnter: func(req *Request, from, to string) {
+			events = append(events, req.Username+" entered "+to+" from "+from)
+		},
+		OnLeave: func(req *Request, from, to string) {
+			events = append(events, req.Username+" left "+from+" for "+to)
+		},
+	})
+
+	for _, message := range []string{"play", "help", "thanks", "quit", "resume", "pause"} {
+		if _, err := bot.Reply("alice", message); err != nil {
+			t.Errorf("%s: unexpected error: %v", message, err)
+		}
+	}
+	expect := []string{
+		"alice entered game from random",
+		"alice left game for help",
+		"alice entered game from help",
+		"alice left game for random",
+		"alice entered game from random",
+		"alice left game for random",
+	}
+	if !reflect.DeepEqual(events, expect) {
+		t.Errorf("expected events %q, got %q", expect, events)
+	}
+}
+
+func TestTopicTimeout(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		! topic game timeout = 60
+		! topic game timeout_topic = lobby
+		! topic room timeout = 5m
+
+		+ play
+		- {topic=game}Let's play.
+
+		+ *
+		- You're not playing.
+
+		> topic game
+			+ enter the room
+			- {topic=room}You're in the room.
+
+			+ *
+			- You're still playing.
+		< topic
+
+		> topic room includes game
+		< topic
+
+		> topic lobby
+			+ *
+			- Welcome back to the lobby.
+		< topic
+	`)
+	bot.SortReplies()
+
+	if cfg := bot.GetTopicConfig("game"); cfg.Timeout != time.Minute || cfg.TimeoutTopic != "lobby" {
+		t.Errorf("unexpected topic config from the ! topic lines: %+v", cfg)
+	}
+
+	// idle pretends that the user's last message was a while ago.
+	idle := func(d time.Duration) {
+		bot.sessions.Set("alice", map[string]string{lastMessageVar: time.Now().Add(-d).Format(time.RFC3339Nano)})
+	}
+
+	bot.Reply("alice", "play")
+	idle(30 * time.Second)
+	if reply, _ := bot.Reply("alice", "hello"); reply != "You're still playing." {
+		t.Errorf("expected to still be playing, got %q", reply)
+	}
+
+	idle(2 * time.Minute)
+	details, err := bot.ReplyDetailed("alice", "hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if details.Reply != "Welcome back to the lobby." {
+		t.Errorf("expected the user to time out into the lobby, got %q", details.Reply)
+	}
+	if len(details.TopicChanges) != 1 || details.TopicChanges[0] != (TopicChange{From: "game", To: "lobby"}) {
+		t.Errorf("unexpected topic changes: %+v", details.TopicChanges)
+	}
+
+	// A topic's own timeout wins over the ones it includes.
+	bot.SetUservar("alice", "topic", "game")
+	bot.Reply("alice", "enter the room")
+	idle(2 * time.Minute)
+	if reply, _ := bot.Reply("alice", "hello"); reply != "You're still playing." {
+		t.Errorf("expected the room's timeout to be used, got %q", reply)
+	}
+	idle(10 * time.Minute)
+	if reply, _ := bot.Reply("alice", "hello"); reply != "You're not playing." {
+		t.Errorf("expected the user to time out of the room, got %q", reply)
+	}
+}
@@ -0,0 +1,84 @@
+package rivescript
+
+// The topic stack.
+
+import "strings"
+
+// topicStackVar is the user variable that holds a user's topic stack. It's a
+// reserved variable, so GetUservars() leaves it out and SetUservar() can't
+// change it.
+const topicStackVar = "__topicstack__"
+
+/*
+topicStack gets the topics that a user can return to with `{topic-}`, from the
+oldest to the newest.
+*/
+func (rs *RiveScript) topicStack(req *Request) []string {
+	value, err := req.sessions.Get(req.Username, topicStackVar)
+	if err != nil || value == "" || value == UNDEFINED {
+		return []string{}
+	}
+	return strings.Fields(value)
+}
+
+// setTopicStack stores a user's topic stack.
+func (rs *RiveScript) setTopicStack(req *Request, stack []string) {
+	req.sessions.Set(req.Username, map[string]string{
+		topicStackVar: strings.Join(stack, " "),
+	})
+}
+
+// currentTopic gets the user's topic, which is "random" if they don't have one.
+func (rs *RiveScript) currentTopic(req *Request) string {
+	topic, err := req.sessions.Get(req.Username, "topic")
+	if err != nil || topic == "" || topic == UNDEFINED {
+		return "random"
+	}
+	return topic
+}
+
+/*
+processTopicStack handles the topic stack tags in a reply, in the order they
+appear in it.
+
+	{topic+=name}  Save the user's topic on the stack and move them to a new one.
+	{topic-}       Move the user back to the topic on top of the stack, or to
+	               "random" if the stack is empty.
+*/
+func (rs *RiveScript) processTopicStack(req *Request, reply string) string {
+	if !strings.Contains(reply, "{topic+=") && !strings.Contains(reply, "{topic-}") {
+		return reply
+	}
+
+	return reTopicStack.ReplaceAllStringFunc(reply, func(tag string) string {
+		stack := rs.topicStack(req)
+		if tag == "{topic-}" {
+			topic := "random"
+			if len(stack) > 0 {
+				topic = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			rs.say("Pop topic %s from the stack", topic)
+			rs.setTopicStack(req, stack)
+			rs.setTopic(req, topic)
+			return ""
+		}
+
+		name := strings.TrimSpace(reTopicStack.FindStringSubmatch(tag)[1])
+		rs.say("Push topic %s onto the stack", name)
+		stack = append(stack, rs.currentTopic(req))
+		if len(stack) > int(rs.Depth) {
+			rs.warn("Topic stack for %s is too deep; forgetting %s", req.Username, stack[0])
+			stack = stack[1:]
+		}
+		rs.setTopicStack(req, stack)
+		rs.setTopic(req, name)
+		return ""
+	})
+}
+
+// topicStackText is the text for the `<topicstack>` tag: the topics on the
+// user's stack followed by their current topic.
+func (rs *RiveScript) topicStackText(req *Request) string {
+	return strings.Join(append(rs.topicStack(req), rs.currentTopic(req)), " ")
+}
@@ -0,0 +1,87 @@
+package rivescript
+
+import "testing"
+
+func TestTopicStack(t *testing.T) {
+	bot := New(nil)
+	bot.Stream(`
+		+ play a game
+		- {topic=game}Let's play. Say "help" any time.
+
+		+ where am i
+		- You're in <topicstack>.
+
+		> topic game
+			+ help
+			- {topic+=help}What do you need help with? (<topicstack>)
+
+			+ where am i
+			- You're playing (<topicstack>).
+
+			+ *
+			- That's not a move.
+		< topic
+
+		> topic help
+			+ rules
+			- {topic+=rules}The rules are simple.
+
+			+ thanks
+			- {topic-}You're welcome, back to it.
+
+			+ where am i
+			- You're getting help (<topicstack>).
+
+			+ *
+			- I can help with the rules.
+		< topic
+
+		> topic rules
+			+ ok
+			- {topic-}{topic-}Back to the game.
+		< topic
+
+		+ leave
+		- {topic-}OK.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		reply   string
+		topic   string
+	}{
+		{"where am i", "You're in random.", "random"},
+		{"play a game", `Let's play. Say "help" any time.`, "game"},
+		{"help", "What do you need help with? (game)", "help"},
+		{"what", "I can help with the rules.", "help"},
+		{"where am i", "You're getting help (game help).", "help"},
+		{"thanks", "You're welcome, back to it.", "game"},
+		{"where am i", "You're playing (game).", "game"},
+
+		// Two levels deep, and back out in one reply.
+		{"help", "What do you need help with? (game)", "help"},
+		{"rules", "The rules are simple.", "rules"},
+		{"ok", "Back to the game.", "game"},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+		} else if reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.message, test.reply, reply)
+		}
+		if topic, _ := bot.GetUservar("alice", "topic"); topic != test.topic {
+			t.Errorf("%s: expected topic %s, got %s", test.message, test.topic, topic)
+		}
+	}
+
+	// Popping an empty stack goes back to random.
+	bot.SetUservar("bob", "topic", "")
+	if reply, _ := bot.Reply("bob", "leave"); reply != "OK." {
+		t.Errorf("expected an empty topic to fall back to random, got %q", reply)
+	}
+	if topic, _ := bot.GetUservar("bob", "topic"); topic != "random" {
+		t.Errorf("expected topic random, got %s", topic)
+	}
+}
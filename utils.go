@@ -3,9 +3,12 @@ package rivescript
 // Miscellaneous utility functions.
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/aichaos/rivescript-go/sessions"
 )
 
 // randomInt gets a random number using RiveScript's internal RNG.
@@ -15,6 +18,15 @@ func (rs *RiveScript) randomInt(max int) int {
 	return rs.rng.Intn(max)
 }
 
+// sessionsFor returns the session manager to use for a request. If the
+// session manager supports contexts, it is bound to the request's context.
+func (rs *RiveScript) sessionsFor(ctx context.Context) sessions.SessionManager {
+	if cs, ok := rs.sessions.(sessions.ContextSessionManager); ok {
+		return cs.WithContext(ctx)
+	}
+	return rs.sessions
+}
+
 // wordCount counts the number of real words in a string.
 func wordCount(pattern string, all bool) int {
 	var words []string
@@ -134,7 +146,11 @@ Params:
 	        placeholders like $1 in this string.
 */
 func regReplace(input string, pattern string, result string) string {
-	reg := regexp.MustCompile(pattern)
+	return regReplaceCompiled(input, regexp.MustCompile(pattern), result)
+}
+
+// regReplaceCompiled is regReplace for a regexp that's already compiled.
+func regReplaceCompiled(input string, reg *regexp.Regexp, result string) string {
 	match := reg.FindStringSubmatch(input)
 	input = reg.ReplaceAllString(input, result)
 	if len(match) > 1 {
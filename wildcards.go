@@ -0,0 +1,96 @@
+package rivescript
+
+// Custom wildcard classes in triggers.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// wildcardClass is a custom wildcard, from RegisterWildcard() or
+// RegisterWildcardFunc().
+type wildcardClass struct {
+	pattern string            // The regexp it matches
+	valid   func(string) bool // Checks what it matched; may be nil
+}
+
+// The names of wildcard classes, and the tags that can't be used as names
+// because triggers use them for something else.
+var (
+	reWildcardName   = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	reservedWildcard = regexp.MustCompile(`^(?:bot|get|input\d*|reply\d*|zerowidthstar)$`)
+)
+
+// checkWildcardName makes sure a name can be used for a wildcard class.
+func checkWildcardName(name string) error {
+	if !reWildcardName.MatchString(name) {
+		return fmt.Errorf("invalid wildcard name %q: it must be lowercase letters and numbers", name)
+	}
+	if reservedWildcard.MatchString(name) {
+		return fmt.Errorf("invalid wildcard name %q: <%s> is already used in triggers", name, name)
+	}
+	return nil
+}
+
+// wildcardClasses gets a copy of the wildcard classes.
+func (rs *RiveScript) wildcardClasses() map[string]wildcardClass {
+	rs.cLock.Lock()
+	defer rs.cLock.Unlock()
+
+	classes := map[string]wildcardClass{}
+	for name, class := range rs.wildcards {
+		classes[name] = class
+	}
+	return classes
+}
+
+// hasWildcardClass tells whether a trigger pattern uses a wildcard class.
+func (rs *RiveScript) hasWildcardClass(pattern string) bool {
+	if !strings.Contains(pattern, "<") {
+		return false
+	}
+	classes := rs.wildcardClasses()
+	for _, match := range reWildcardTag.FindAllStringSubmatch(pattern, -1) {
+		if _, ok := classes[match[1]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+wildcardGroup names the regexp group of a wildcard class, so the matcher can
+tell which class captured each star. The group is named `name__class`, or
+`__class_n` if the wildcard wasn't given a name.
+*/
+func wildcardGroup(name, class string, n int) string {
+	if name == "" {
+		return fmt.Sprintf("__%s_%d", class, n)
+	}
+	return name + "__" + class
+}
+
+// parseWildcardGroup splits the name of a regexp group into the name of its
+// star and the wildcard class it's for, if it's one of wildcardGroup().
+func parseWildcardGroup(group string, classes map[string]wildcardClass) (name, class string) {
+	i := strings.LastIndex(group, "__")
+	if i < 0 {
+		return group, ""
+	}
+
+	name, class = group[:i], group[i+2:]
+	if name == "" {
+		// An unnamed one ends with _n.
+		if j := strings.LastIndex(class, "_"); j >= 0 {
+			if _, err := strconv.Atoi(class[j+1:]); err == nil {
+				class = class[:j]
+			}
+		}
+	}
+	if _, ok := classes[class]; !ok {
+		return group, ""
+	}
+	return name, class
+}
@@ -0,0 +1,109 @@
+package rivescript
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterWildcard(t *testing.T) {
+	bot := New(&Config{UTF8: true})
+	bot.SetUnicodePunctuation(`[,!?;:]`)
+
+	// Bad names and regexps.
+	for _, name := range []string{"Email", "first_name", "get", "input2", ""} {
+		if err := bot.RegisterWildcard(name, `\w+`); err == nil {
+			t.Errorf("expected an error for the wildcard name %q", name)
+		}
+	}
+	if err := bot.RegisterWildcard("broken", `(`); err == nil {
+		t.Errorf("expected an error for a bad regexp")
+	}
+
+	if err := bot.RegisterWildcard("email", `[^\s@]+@[^\s@]+\.[a-z]+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.RegisterWildcard("decimal", `\d+\.\d+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.RegisterWildcardFunc("date", func(value string) bool {
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.Stream(`
+		+ my email is <email>
+		- I'll write to <star>.
+
+		+ my email is *
+		- That's not an e-mail address.
+
+		+ send <decimal>{amount} to <email>{to}
+		- Sending <star amount> to <star to> (<star1>, <star2>).
+
+		+ i was born on <date>
+		- Happy birthday on <star>!
+
+		+ i was born on *
+		- That's not a date.
+
+		+ [please] pay <decimal>
+		- Paying <star>.
+
+		+ *
+		- I don't understand.
+	`)
+	bot.SortReplies()
+
+	tests := []struct {
+		message string
+		reply   string
+	}{
+		{"my email is alice@example.com", "I'll write to alice@example.com."},
+		{"my email is alice", "That's not an e-mail address."},
+		{"send 3.50 to bob@example.com", "Sending 3.50 to bob@example.com (3.50, bob@example.com)."},
+		{"send 3 to bob@example.com", "I don't understand."},
+		{"i was born on 1990-05-17", "Happy birthday on 1990-05-17!"},
+		{"i was born on 1990-13-45", "That's not a date."},
+		{"please pay 1.25", "Paying 1.25."},
+		{"pay 2.00", "Paying 2.00."},
+	}
+	for _, test := range tests {
+		reply, err := bot.Reply("alice", test.message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+		} else if reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.message, test.reply, reply)
+		}
+	}
+
+	details, _ := bot.ReplyDetailed("alice", "send 1.5 to carol@example.com")
+	expect := map[string]string{"amount": "1.5", "to": "carol@example.com"}
+	if !reflect.DeepEqual(details.NamedStars, expect) {
+		t.Errorf("expected named stars %v, got %v", expect, details.NamedStars)
+	}
+
+	// Triggers with wildcard classes come before the ones with wildcards.
+	var classAt, starAt int
+	for i, trig := range bot.sorted.topics["random"] {
+		switch trig.trigger {
+		case "my email is <email>":
+			classAt = i
+		case "my email is *":
+			starAt = i
+		}
+	}
+	if classAt > starAt {
+		t.Errorf("expected the wildcard class to be sorted first: %d > %d", classAt, starAt)
+	}
+
+	// Unregistered classes are left as they are.
+	bot.UnregisterWildcard("date")
+	bot.SortReplies()
+	if reply, _ := bot.Reply("alice", "i was born on 1990-05-17"); !strings.HasPrefix(reply, "That's not") {
+		t.Errorf("expected the date wildcard to be gone, got %q", reply)
+	}
+}